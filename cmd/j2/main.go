@@ -21,6 +21,7 @@ import (
 )
 
 func main() {
+	internal.CheckAndRunCommand()
 	internal.CheckAndPrintVersion()
 	internal.CheckAndPrintUsageGuide()
 
@@ -6,6 +6,8 @@ require (
 	github.com/c-bata/go-prompt v0.2.6
 	github.com/fatih/color v1.10.0
 	github.com/mattn/go-runewidth v0.0.9
+	github.com/pkg/sftp v1.13.0
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4
 	gopkg.in/yaml.v2 v2.4.0
 )
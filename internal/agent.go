@@ -0,0 +1,103 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var agents = make(map[string]*agentConn)
+var agentsMu sync.Mutex
+
+type agentConn struct {
+	agent.ExtendedAgent
+	conn net.Conn
+}
+
+func agentSocket(sock string) string {
+	if sock == "" || sock == "SSH_AUTH_SOCK" {
+		return os.Getenv("SSH_AUTH_SOCK")
+	}
+	return Cfg.path(os.ExpandEnv(sock))
+}
+
+func agentClient(sock string) (agent.ExtendedAgent, error) {
+	sock = agentSocket(sock)
+	if sock == "" {
+		return nil, errors.New("the ssh-agent socket is unknown, SSH_AUTH_SOCK is not set")
+	}
+
+	agentsMu.Lock()
+	defer agentsMu.Unlock()
+
+	if a := agents[sock]; a != nil {
+		return a, nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	a := &agentConn{ExtendedAgent: agent.NewClient(conn), conn: conn}
+	agents[sock] = a
+	return a, nil
+}
+
+func dropAgentClient(sock string) {
+	sock = agentSocket(sock)
+
+	agentsMu.Lock()
+	defer agentsMu.Unlock()
+
+	if a := agents[sock]; a != nil {
+		doClose(a.conn)
+		delete(agents, sock)
+	}
+}
+
+func agentSigners(sock string) ([]ssh.Signer, error) {
+	a, err := agentClient(sock)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := a.Signers()
+	if err != nil {
+		// The agent may have been restarted, try again with a new connection.
+		dropAgentClient(sock)
+		if a, err = agentClient(sock); err != nil {
+			return nil, err
+		}
+		return a.Signers()
+	}
+	return signers, nil
+}
+
+func forwardAgent(client *ssh.Client, sess *ssh.Session, sock string) error {
+	if sock = agentSocket(sock); sock == "" {
+		return errors.New("the ssh-agent socket is unknown, SSH_AUTH_SOCK is not set")
+	}
+	// Each forwarded request opens its own connection to the local agent.
+	// The handler is already registered if the connection is reused.
+	if err := agent.ForwardToRemote(client, sock); err != nil && !strings.Contains(err.Error(), "already have handler") {
+		return err
+	}
+	return agent.RequestAgentForwarding(sess)
+}
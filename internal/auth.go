@@ -0,0 +1,278 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	AuthPublicKey           = "publickey"
+	AuthAgent               = "agent"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	AuthPassword            = "password"
+)
+
+var DefaultAuthMethods = []string{AuthPublicKey, AuthAgent, AuthKeyboardInteractive, AuthPassword}
+
+var signers = make(map[string]ssh.Signer)
+var signersMu sync.Mutex
+
+func (c *Config) checkKey(key string) error {
+	key = c.path(key)
+
+	signersMu.Lock()
+	defer signersMu.Unlock()
+
+	if signers[key] != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(key)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		// The passphrase is asked for when the key is used for the first time.
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			return nil
+		}
+		return fmt.Errorf("parse private key %s: %s", key, err)
+	}
+	signers[key] = signer
+	return nil
+}
+
+func (c *Config) signer(key, command string) (ssh.Signer, error) {
+	key = c.path(key)
+
+	signersMu.Lock()
+	defer signersMu.Unlock()
+
+	if signer := signers[key]; signer != nil {
+		return signer, nil
+	}
+	data, err := ioutil.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+			return nil, fmt.Errorf("parse private key %s: %s", key, err)
+		}
+		if signer, err = c.decrypt(key, data, command); err != nil {
+			return nil, err
+		}
+	}
+	signers[key] = signer
+	return signer, nil
+}
+
+func (c *Config) decrypt(key string, data []byte, command string) (ssh.Signer, error) {
+	if command != "" {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = append(os.Environ(), "J2_PRIVATE_KEY="+key)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("run passphrase command for key %s: %s", key, err)
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(data, bytes.TrimRight(out, "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt private key %s: %s", key, err)
+		}
+		return signer, nil
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("private key %s is encrypted and there is no terminal to ask for the passphrase", key)
+	}
+	for i := 0; i < 3; i++ {
+		pass, err := AskPassword("Enter passphrase for key '%s': ", key)
+		if err != nil {
+			return nil, err
+		}
+		if pass == "" {
+			break
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(pass))
+		if err == nil {
+			return signer, nil
+		}
+		if err != x509.IncorrectPasswordError {
+			return nil, fmt.Errorf("decrypt private key %s: %s", key, err)
+		}
+		Error("Bad passphrase, try again.")
+	}
+	return nil, fmt.Errorf("no passphrase for private key %s", key)
+}
+
+// The key files and the agent keys are all offered in one publickey method,
+// because the client never retries a method that has already failed.
+func (c *Config) publicKeys(s *Server, methods []string) (ssh.AuthMethod, error) {
+	var keys []string
+	if indexOf(methods, AuthPublicKey) >= 0 {
+		keys = s.keys()
+		for i, j := 0, len(keys); i < j; i++ {
+			if err := c.checkKey(keys[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	agent := indexOf(methods, AuthAgent) >= 0
+	if len(keys) == 0 && !agent {
+		return nil, nil
+	}
+	agentFirst := agent && indexOf(methods, AuthAgent) < indexOf(methods, AuthPublicKey)
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		list := make([]ssh.Signer, 0, len(keys))
+		for i, j := 0, len(keys); i < j; i++ {
+			signer, err := c.signer(keys[i], s.PassphraseCommand)
+			if err != nil {
+				Warn("Skip private key: %s", err)
+				continue
+			}
+			list = append(list, signer)
+		}
+		if !agent {
+			return list, nil
+		}
+		agentList, err := agentSigners(s.IdentityAgent)
+		if err != nil {
+			if len(list) == 0 {
+				return nil, err
+			}
+			Warn("Can not use ssh-agent: %s", err)
+			return list, nil
+		}
+		if agentFirst {
+			return append(agentList, list...), nil
+		}
+		return append(list, agentList...), nil
+	}), nil
+}
+
+func (c *Config) auth(s *Server) ([]ssh.AuthMethod, error) {
+	methods := s.AuthMethods
+	if len(methods) == 0 {
+		methods = make([]string, 0, len(DefaultAuthMethods))
+		for i, j := 0, len(DefaultAuthMethods); i < j; i++ {
+			switch DefaultAuthMethods[i] {
+			case AuthPublicKey:
+				if len(s.keys()) == 0 {
+					continue
+				}
+			case AuthAgent:
+				if !*s.Agent {
+					continue
+				}
+			case AuthKeyboardInteractive:
+				if s.Password == "" {
+					continue
+				}
+			case AuthPassword:
+				if s.Password == "" && len(methods) > 0 {
+					continue
+				}
+			}
+			methods = append(methods, DefaultAuthMethods[i])
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	var keys bool
+	for i, j := 0, len(methods); i < j; i++ {
+		switch methods[i] {
+		case AuthPublicKey, AuthAgent:
+			if keys {
+				continue
+			}
+			keys = true
+			method, err := c.publicKeys(s, methods)
+			if err != nil {
+				return nil, err
+			}
+			if method != nil {
+				auth = append(auth, method)
+			}
+		case AuthKeyboardInteractive:
+			auth = append(auth, ssh.KeyboardInteractive(keyboardInteractive(s)))
+		case AuthPassword:
+			auth = append(auth, ssh.PasswordCallback(password(s)))
+		default:
+			return nil, fmt.Errorf("unknown auth method %q of server %s", methods[i], s.Name)
+		}
+	}
+	return auth, nil
+}
+
+func (s *Server) keys() []string {
+	keys := make([]string, 0, len(s.PrivateKeys)+1)
+	if s.PrivateKey != "" {
+		keys = append(keys, s.PrivateKey)
+	}
+	return append(keys, s.PrivateKeys...)
+}
+
+func password(s *Server) func() (string, error) {
+	return func() (string, error) {
+		if s.Password != "" {
+			return s.Password, nil
+		}
+		return AskPassword("%s@%s's password: ", s.User, s.Host)
+	}
+}
+
+func keyboardInteractive(s *Server) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		if instruction != "" {
+			Echo(instruction)
+		}
+		answers := make([]string, len(questions))
+		for i, j := 0, len(questions); i < j; i++ {
+			var err error
+			if s.Password != "" && !echos[i] && strings.Contains(strings.ToLower(questions[i]), "password") {
+				answers[i] = s.Password
+			} else if echos[i] {
+				answers[i], err = Ask("%s", questions[i])
+			} else {
+				answers[i], err = AskPassword("%s", questions[i])
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return answers, nil
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, j := 0, len(list); i < j; i++ {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
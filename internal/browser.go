@@ -0,0 +1,343 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/c-bata/go-prompt"
+	"github.com/fatih/color"
+)
+
+var BrowserSuggests = []prompt.Suggest{
+	{Text: "ls", Description: "List the remote directory: ls [path]."},
+	{Text: "cd", Description: "Change the remote directory: cd [path]."},
+	{Text: "pwd", Description: "Print the remote directory."},
+	{Text: "get", Description: "Download the remote file or directory: get <remote> [local]."},
+	{Text: "put", Description: "Upload the local file or directory: put <local> [remote]."},
+	{Text: "rm", Description: "Remove the remote file or directory: rm [-r] <path>."},
+	{Text: "mkdir", Description: "Create the remote directory: mkdir <path>."},
+	{Text: "rename", Description: "Rename the remote file or directory: rename <old> <new>."},
+	{Text: "help", Description: "Display the commands of the file browser."},
+	{Text: "exit", Description: "Return to the server list."},
+}
+
+// Browser is an interactive file browser of a remote server.
+type Browser struct {
+	server *Server
+	sftp   *SFTP
+	home   string
+	wd     string
+	exit   bool
+	cache  map[string][]os.FileInfo
+}
+
+func Browse(s *Server) error {
+	c, err := OpenSFTP(s)
+	if err != nil {
+		return err
+	}
+	defer doClose(c)
+
+	home, err := c.Getwd()
+	if err != nil {
+		return err
+	}
+	b := &Browser{server: s, sftp: c, home: home, wd: home}
+
+	ClearScreen()
+	Echo(color.GreenString("Browsing the files of %s, use help to view the commands and exit or <Control+D> to return.", s.Name))
+	p := prompt.New(b.Executor, b.Completer,
+		prompt.OptionTitle("J2 - "+s.Name),
+		prompt.OptionPrefix("sftp >> "),
+		prompt.OptionLivePrefix(b.prefix),
+		prompt.OptionPrefixTextColor(prompt.Blue),
+		prompt.OptionPrefixBackgroundColor(prompt.DefaultColor),
+		prompt.OptionSuggestionTextColor(prompt.Brown),
+		prompt.OptionSuggestionBGColor(prompt.DefaultColor),
+		prompt.OptionSelectedSuggestionTextColor(prompt.Red),
+		prompt.OptionSelectedSuggestionBGColor(prompt.Yellow),
+		prompt.OptionDescriptionTextColor(prompt.Cyan),
+		prompt.OptionDescriptionBGColor(prompt.DefaultColor),
+		prompt.OptionSelectedDescriptionTextColor(prompt.Fuchsia),
+		prompt.OptionSelectedDescriptionBGColor(prompt.Yellow),
+		prompt.OptionCompletionOnDown(),
+		prompt.OptionSetExitCheckerOnInput(func(string, bool) bool { return b.exit }),
+		prompt.OptionParser(DefaultConsoleParserWrapper),
+	)
+	p.Run()
+	return nil
+}
+
+func (b *Browser) prefix() (string, bool) {
+	wd := b.wd
+	if wd == b.home {
+		wd = "~"
+	} else if strings.HasPrefix(wd, b.home+"/") {
+		wd = "~" + wd[len(b.home):]
+	}
+	return fmt.Sprintf("sftp %s:%s >> ", b.server.Name, wd), true
+}
+
+// The relative paths are relative to the current remote directory.
+func (b *Browser) path(p string) string {
+	switch {
+	case p == "" || p == "~":
+		return b.home
+	case strings.HasPrefix(p, "~/"):
+		return path.Join(b.home, p[2:])
+	case path.IsAbs(p):
+		return path.Clean(p)
+	}
+	return path.Join(b.wd, p)
+}
+
+func (b *Browser) Executor(input string) {
+	// The remote files may be changed by the command.
+	b.cache = nil
+
+	args := strings.Fields(input)
+	if len(args) == 0 {
+		return
+	}
+	var err error
+	switch args[0] {
+	case "ls":
+		err = b.ls(args[1:])
+	case "cd":
+		err = b.cd(args[1:])
+	case "pwd":
+		Echo(b.wd)
+	case "get":
+		if len(args) < 2 || len(args) > 3 {
+			err = fmt.Errorf("usage: get <remote> [local]")
+		} else if len(args) == 2 {
+			err = b.sftp.Download(b.path(args[1]), ".")
+		} else {
+			err = b.sftp.Download(b.path(args[1]), args[2])
+		}
+	case "put":
+		if len(args) < 2 || len(args) > 3 {
+			err = fmt.Errorf("usage: put <local> [remote]")
+		} else if len(args) == 2 {
+			err = b.sftp.Upload(args[1], b.wd)
+		} else {
+			err = b.sftp.Upload(args[1], b.path(args[2]))
+		}
+	case "rm":
+		err = b.rm(args[1:])
+	case "mkdir":
+		if len(args) != 2 {
+			err = fmt.Errorf("usage: mkdir <path>")
+		} else {
+			err = b.sftp.MkdirAll(b.path(args[1]))
+		}
+	case "rename":
+		if len(args) != 3 {
+			err = fmt.Errorf("usage: rename <old> <new>")
+		} else {
+			err = b.sftp.Rename(b.path(args[1]), b.path(args[2]))
+		}
+	case "help":
+		for i, j := 0, len(BrowserSuggests); i < j; i++ {
+			Echo(color.GreenString("  %-6s  %s", BrowserSuggests[i].Text, BrowserSuggests[i].Description))
+		}
+	case "exit":
+		b.exit = true
+	default:
+		err = fmt.Errorf("command %q is invalid, please use help to view the commands", args[0])
+	}
+	if err != nil {
+		Error("%s", err)
+	}
+}
+
+func (b *Browser) ls(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: ls [path]")
+	}
+	p := b.wd
+	if len(args) == 1 {
+		p = b.path(args[0])
+	}
+	info, err := b.sftp.Stat(p)
+	if err != nil {
+		return err
+	}
+	list := []os.FileInfo{info}
+	if info.IsDir() {
+		if list, err = b.sftp.ReadDir(p); err != nil {
+			return err
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	}
+	for i, j := 0, len(list); i < j; i++ {
+		name := list[i].Name()
+		if list[i].IsDir() {
+			name = color.HiBlueString(name + "/")
+		} else if list[i].Mode()&os.ModeSymlink != 0 {
+			name = color.HiCyanString(name)
+		}
+		Echo("%s  %8s  %s  %s", list[i].Mode(), formatSize(list[i].Size()),
+			list[i].ModTime().Format("2006-01-02 15:04"), name)
+	}
+	return nil
+}
+
+func (b *Browser) cd(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: cd [path]")
+	}
+	p := b.home
+	if len(args) == 1 {
+		p = b.path(args[0])
+	}
+	info, err := b.sftp.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", p)
+	}
+	b.wd = p
+	return nil
+}
+
+func (b *Browser) rm(args []string) error {
+	recursive := len(args) > 0 && args[0] == "-r"
+	if recursive {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rm [-r] <path>")
+	}
+	p := b.path(args[0])
+	info, err := b.sftp.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return b.sftp.Remove(p)
+	}
+	if !recursive {
+		return fmt.Errorf("%s is a directory, use rm -r to remove it", p)
+	}
+	// The walker visits a directory before its contents, so they are removed in reverse.
+	var list []string
+	for walker := b.sftp.Walk(p); walker.Step(); {
+		if err = walker.Err(); err != nil {
+			return err
+		}
+		list = append(list, walker.Path())
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		if err = b.sftp.Remove(list[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Browser) Completer(doc prompt.Document) []prompt.Suggest {
+	text := doc.TextBeforeCursor()
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return nil
+	}
+	word := doc.GetWordBeforeCursor()
+	if len(args) == 1 && word != "" {
+		return prompt.FilterHasPrefix(BrowserSuggests, word, true)
+	}
+	// The index of the argument being completed.
+	n := len(args) - 1
+	if word == "" {
+		n++
+	}
+	switch args[0] {
+	case "ls", "cd", "rm", "mkdir", "rename":
+		return b.complete(word, b.readRemoteDir, args[0] == "cd" || args[0] == "mkdir")
+	case "get":
+		if n == 1 {
+			return b.complete(word, b.readRemoteDir, false)
+		}
+		return b.complete(word, readLocalDir, false)
+	case "put":
+		if n == 1 {
+			return b.complete(word, readLocalDir, false)
+		}
+		return b.complete(word, b.readRemoteDir, true)
+	}
+	return nil
+}
+
+// The complete function suggests the entries of the directory of the word,
+// the entries are read only once for each input.
+func (b *Browser) complete(word string, read func(string) ([]os.FileInfo, error), dirs bool) []prompt.Suggest {
+	dir, base := "", word
+	if n := strings.LastIndex(word, "/"); n >= 0 {
+		dir, base = word[:n+1], word[n+1:]
+	}
+	list, err := read(dir)
+	if err != nil {
+		return nil
+	}
+	suggests := make([]prompt.Suggest, 0, len(list))
+	for i, j := 0, len(list); i < j; i++ {
+		name := list[i].Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if list[i].IsDir() {
+			suggests = append(suggests, prompt.Suggest{Text: dir + name + "/", Description: "directory"})
+		} else if !dirs {
+			suggests = append(suggests, prompt.Suggest{Text: dir + name, Description: formatSize(list[i].Size())})
+		}
+	}
+	sort.Slice(suggests, func(i, j int) bool { return suggests[i].Text < suggests[j].Text })
+	return prompt.FilterHasPrefix(suggests, word, false)
+}
+
+func (b *Browser) readRemoteDir(dir string) ([]os.FileInfo, error) {
+	// The bare words are relative to the current directory, not the home directory.
+	p := b.wd
+	if dir != "" {
+		p = b.path(dir)
+	}
+	if list, found := b.cache[p]; found {
+		return list, nil
+	}
+	list, err := b.sftp.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	if b.cache == nil {
+		b.cache = make(map[string][]os.FileInfo)
+	}
+	b.cache[p] = list
+	return list, nil
+}
+
+func readLocalDir(dir string) ([]os.FileInfo, error) {
+	if dir == "" {
+		dir = "."
+	}
+	return ioutil.ReadDir(filepath.FromSlash(Cfg.path(dir)))
+}
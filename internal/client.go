@@ -0,0 +1,308 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is a connected remote server, the jump hosts used to reach the
+// server are closed together with it. The shared connections are only
+// released when closed, see the pool.
+type Client struct {
+	*ssh.Client
+	hops []*ssh.Client
+
+	shared *sharedConn
+	once   sync.Once
+}
+
+func (c *Client) Close() error {
+	if c.shared != nil {
+		c.once.Do(c.shared.release)
+		return nil
+	}
+	err := c.Client.Close()
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		doClose(c.hops[i])
+	}
+	return err
+}
+
+// Dial connects to the server. If ControlPersist is positive, the connection is reused
+// by the later dials of the same server until it is idle for ControlPersist seconds.
+func Dial(s *Server) (*Client, error) {
+	key, ok := poolKey(s)
+	if s.ControlPersist <= 0 || !ok {
+		return dialHops(s)
+	}
+	if c := acquire(key); c != nil {
+		return c, nil
+	}
+	c, err := dialHops(s)
+	if err != nil {
+		return nil, err
+	}
+	return share(s, key, c), nil
+}
+
+func dialHops(s *Server) (*Client, error) {
+	hops, err := Cfg.jumps(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*ssh.Client, 0, len(hops)+1)
+	for i, j := 0, len(hops)+1; i < j; i++ {
+		hop := s
+		if i < len(hops) {
+			hop = hops[i]
+		}
+		var via *ssh.Client
+		if len(clients) > 0 {
+			via = clients[len(clients)-1]
+		}
+		client, err := dialRetry(hop, via)
+		if err != nil {
+			for k := len(clients) - 1; k >= 0; k-- {
+				doClose(clients[k])
+			}
+			if i > 0 {
+				err.Via = hops[i-1].Name
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return &Client{Client: clients[len(clients)-1], hops: clients[:len(clients)-1]}, nil
+}
+
+const (
+	PhaseDNS       = "DNS resolution"
+	PhaseTCP       = "TCP connect"
+	PhaseHandshake = "SSH handshake"
+	PhaseHostKey   = "host key verification"
+	PhaseAuth      = "authentication"
+)
+
+// DialError reports which phase of connecting to a remote server failed.
+type DialError struct {
+	Phase string
+	Name  string
+	Addr  string
+	Via   string
+	Err   error
+}
+
+func (e *DialError) Error() string {
+	if e.Via != "" {
+		return fmt.Sprintf("%s failed for %s (%s) via %s: %s", e.Phase, e.Name, e.Addr, e.Via, e.Err)
+	}
+	return fmt.Sprintf("%s failed for %s (%s): %s", e.Phase, e.Name, e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func dialRetry(s *Server, via *ssh.Client) (*ssh.Client, *DialError) {
+	for attempt := 0; ; attempt++ {
+		client, err := dial(s, via)
+		if err == nil {
+			return client, nil
+		}
+		// Retrying can not fix the credentials or the host key.
+		if attempt >= *s.ConnectRetries || err.Phase == PhaseAuth || err.Phase == PhaseHostKey {
+			return nil, err
+		}
+		Warn("%s, retrying (%d/%d)...", err, attempt+1, *s.ConnectRetries)
+		time.Sleep(time.Second)
+	}
+}
+
+func dial(s *Server, via *ssh.Client) (*ssh.Client, *DialError) {
+	timeout := time.Duration(s.ConnectTimeout) * time.Second
+	fail := func(phase string, err error) *DialError {
+		return &DialError{Phase: phase, Name: s.Name, Addr: s.Addr, Err: reason(err, timeout)}
+	}
+
+	var conn net.Conn
+	var err error
+	if via != nil {
+		// The jump host resolves the name and connects to the server.
+		if conn, err = dialVia(via, s.Addr, timeout); err != nil {
+			return nil, fail(PhaseTCP, err)
+		}
+	} else {
+		addrs := []string{s.Host}
+		if net.ParseIP(s.Host) == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			addrs, err = net.DefaultResolver.LookupHost(ctx, s.Host)
+			cancel()
+			if err != nil {
+				return nil, fail(PhaseDNS, err)
+			}
+		}
+		for i, j := 0, len(addrs); i < j; i++ {
+			if conn, err = net.DialTimeout("tcp", net.JoinHostPort(addrs[i], strconv.Itoa(s.Port)), timeout); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fail(PhaseTCP, err)
+		}
+	}
+
+	config := Cfg.clientConfig(s)
+	// The deadline covers the key exchange only, because the user may be asked
+	// to trust the host key or to enter a password after that.
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	callback := config.HostKeyCallback
+	var hostKeyErr error
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		_ = conn.SetDeadline(time.Time{})
+		hostKeyErr = callback(hostname, remote, key)
+		return hostKeyErr
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.Addr, config)
+	if err != nil {
+		doClose(conn)
+		if hostKeyErr != nil {
+			return nil, fail(PhaseHostKey, hostKeyErr)
+		}
+		// The handshake errors are not wrapped, only the message is left.
+		msg := strings.TrimPrefix(err.Error(), "ssh: handshake failed: ")
+		if strings.Contains(msg, "unable to authenticate") {
+			return nil, fail(PhaseAuth, errors.New(msg))
+		}
+		if strings.HasSuffix(msg, "i/o timeout") {
+			return nil, fail(PhaseHandshake, fmt.Errorf("timed out after %s", timeout))
+		}
+		return nil, fail(PhaseHandshake, errors.New(msg))
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// The dialVia function connects to the address through the jump host, the channel
+// opened by the jump host has no timeout, so a late connection is closed.
+func dialVia(via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-done; r.err == nil {
+				doClose(r.conn)
+			}
+		}()
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// The reason function turns the error into a short human readable reason.
+func reason(err error, timeout time.Duration) error {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errors.New(dnsErr.Err)
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Err != nil {
+		if e, ok := opErr.Err.(*os.SyscallError); ok {
+			return e.Err
+		}
+		return opErr.Err
+	}
+	return err
+}
+
+func (c *Config) clientConfig(s *Server) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:              s.User,
+		Auth:              s.Auth,
+		HostKeyCallback:   c.hostKeyCallback(s),
+		HostKeyAlgorithms: c.hostKeyAlgorithms(s),
+	}
+}
+
+// The jump hosts of a jump host are reached first, just like ProxyJump of OpenSSH.
+func (c *Config) jumps(s *Server, seen []string) ([]*Server, error) {
+	if len(s.Jump) == 0 {
+		return nil, nil
+	}
+	if indexOf(seen, s.Name) >= 0 {
+		return nil, fmt.Errorf("jump host loop detected: %s", strings.Join(append(seen, s.Name), " -> "))
+	}
+	seen = append(seen, s.Name)
+
+	var hops []*Server
+	for i, j := 0, len(s.Jump); i < j; i++ {
+		hop, err := c.jump(s.Jump[i])
+		if err != nil {
+			return nil, err
+		}
+		list, err := c.jumps(hop, seen)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(append(hops, list...), hop)
+	}
+	return hops, nil
+}
+
+func (c *Config) jump(spec string) (*Server, error) {
+	if found, err := c.Find(spec); err != nil || found != nil {
+		return found, err
+	}
+
+	s := &Server{Name: spec, Host: spec}
+	if n := strings.LastIndex(s.Host, "@"); n >= 0 {
+		s.User, s.Host = s.Host[:n], s.Host[n+1:]
+	}
+	if strings.HasPrefix(s.Host, "[") || strings.Count(s.Host, ":") == 1 {
+		host, port, err := net.SplitHostPort(s.Host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host %q: %s", spec, err)
+		}
+		if s.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid jump host %q: bad port %s", spec, port)
+		}
+		s.Host = host
+	}
+	if err := c.init(s); err != nil {
+		return nil, fmt.Errorf("invalid jump host %q: %s", spec, err)
+	}
+	return s, nil
+}
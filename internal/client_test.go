@@ -0,0 +1,87 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDialViaTimeout(t *testing.T) {
+	srv := newSSHTestServer(t)
+	jump := srv.server(t, "jump")
+	newTestConfig(t, jump)
+	client, err := Dial(jump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doClose(client)
+	echo := newEchoServer(t)
+
+	conn, err := dialVia(client.Client, echo.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doClose(conn)
+
+	srv.delay = time.Second
+	started := time.Now()
+	timeout := time.Millisecond * 100
+	if _, err = dialVia(client.Client, echo.Addr().String(), timeout); err != os.ErrDeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed >= srv.delay {
+		t.Errorf("the dial returned after %s", elapsed)
+	}
+	if got := reason(err, timeout).Error(); got != "timed out after 100ms" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestConnectRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	doClose(ln)
+
+	none := 0
+	inherited := &Server{Name: "inherited", Host: "127.0.0.1", Port: addr.Port}
+	disabled := &Server{Name: "disabled", Host: "127.0.0.1", Port: addr.Port, ConnectRetries: &none}
+	Cfg = NewConfig()
+	Cfg.ConnectRetries = 1
+	for _, s := range []*Server{inherited, disabled} {
+		if err = Cfg.init(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *inherited.ConnectRetries != 1 || *disabled.ConnectRetries != 0 {
+		t.Fatalf("got %d and %d", *inherited.ConnectRetries, *disabled.ConnectRetries)
+	}
+
+	// The retries wait for a second between the attempts.
+	for _, s := range []*Server{inherited, disabled} {
+		started := time.Now()
+		if _, err := dialRetry(s, nil); err == nil || err.Phase != PhaseTCP {
+			t.Fatalf("%s: got %v", s.Name, err)
+		}
+		if retried := time.Since(started) >= time.Second; retried != (s == inherited) {
+			t.Errorf("%s: retried %t", s.Name, retried)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fatih/color"
+)
+
+// Command is a subcommand of J2, it runs without the interactive server list.
+type Command struct {
+	Name        string
+	Args        string
+	Description string
+	Run         func(args []string) int
+	NoConfig    bool // The command can run without a config file.
+}
+
+var Commands = []*Command{
+	{
+		Name:        "exec",
+		Args:        "[-t] <name> -- <command> | -g <group> [-c num] [-o dir] -- <command>",
+		Description: "Run the command on the server, or on all servers of the group in parallel.",
+		Run:         RunExec,
+	},
+	{
+		Name:        "export",
+		Args:        "[--format ssh-config|json|csv|ansible] [-g group]",
+		Description: "Print the servers in the format of the ssh config file, JSON, CSV or Ansible inventory.",
+		Run:         RunExport,
+	},
+	{
+		Name:        "get",
+		Args:        "<name>:<remote> <local>",
+		Description: "Download the remote file or directory from the server.",
+		Run:         RunGet,
+	},
+	{
+		Name:        "import",
+		Args:        "ssh-config [-f file] [-g group] [-w]",
+		Description: "Import the servers of the ssh config file.",
+		Run:         RunImport,
+		NoConfig:    true,
+	},
+	{
+		Name:        "play",
+		Args:        "[-s speed] [-i idle] <file>",
+		Description: "Replay the session recording in the terminal.",
+		Run:         RunPlay,
+	},
+	{
+		Name:        "put",
+		Args:        "<local> <name>:<remote>",
+		Description: "Upload the local file or directory to the server.",
+		Run:         RunPut,
+	},
+	{
+		Name:        "socks",
+		Args:        "[-b bind] [-p port] <name>",
+		Description: "Start a SOCKS5 proxy through the server without opening a shell.",
+		Run:         RunSocks,
+	},
+}
+
+func CheckAndRunCommand() {
+	if len(os.Args) < 2 {
+		return
+	}
+	for i, j := 0, len(Commands); i < j; i++ {
+		if Commands[i].Name == os.Args[1] {
+			if err := Cfg.Init(); err != nil && !(err == ErrNoConfigFile && Commands[i].NoConfig) {
+				ErrorAndExit("Init config failed: %s", err)
+			}
+			Exit(Commands[i].Run(os.Args[2:]))
+		}
+	}
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		Echo("Usage: j2 %s %s", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func findServer(name string) (*Server, error) {
+	s, err := Cfg.Find(name)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("remote server %s not found", name)
+	}
+	return s, nil
+}
+
+func RunPut(args []string) int {
+	flags := newFlagSet("put", "<local> <name>:<remote>")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	if err := Put(flags.Arg(0), flags.Arg(1)); err != nil {
+		Error("Upload %s error: %s", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func RunGet(args []string) int {
+	flags := newFlagSet("get", "<name>:<remote> <local>")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	if err := Get(flags.Arg(0), flags.Arg(1)); err != nil {
+		Error("Download %s error: %s", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func RunPlay(args []string) int {
+	flags := newFlagSet("play", "[-s speed] [-i idle] <file>")
+	speed := flags.Float64("s", 1, "The playback speed, 2 plays twice as fast.")
+	idle := flags.Duration("i", 0, "Limit the idle time between the outputs, for example 2s.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *speed <= 0 || *idle < 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := Play(flags.Arg(0), *speed, *idle); err != nil {
+		Error("Play %s error: %s", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func RunSocks(args []string) int {
+	flags := newFlagSet("socks", "[-b bind] [-p port] <name>")
+	bind := flags.String("b", "127.0.0.1", "The local address to listen on.")
+	port := flags.Int("p", 0, "The local port to listen on (default socksPort of the server, or 1080).")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	s, err := findServer(flags.Arg(0))
+	if err != nil {
+		Error("%s", err)
+		return 1
+	}
+	if *port == 0 {
+		if *port = s.SocksPort; *port == 0 {
+			*port = 1080
+		}
+	}
+
+	client, err := Dial(s)
+	if err != nil {
+		Error("Connect to server %s error: %s", s.Name, err)
+		return 1
+	}
+	defer doClose(client)
+
+	forwarder := NewForwarder(client.Client)
+	defer forwarder.Close()
+	addr, err := forwarder.Dynamic(*bind, *port)
+	if err != nil {
+		Error("Listen SOCKS5 proxy error: %s", err)
+		return 1
+	}
+	Echo(color.GreenString("SOCKS5 proxy is listening on %s through %s, press <Control+C> to stop.", addr, s.Name))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+
+	select {
+	case <-signals:
+		return 0
+	case err = <-done:
+		Error("Connection to server %s closed: %v", s.Name, err)
+		return 1
+	}
+}
@@ -33,6 +33,8 @@ import (
 
 var Cfg = NewConfig()
 
+var ErrNoConfigFile = errors.New("no config file")
+
 type Config struct {
 	PageSize   int       `yaml:"pageSize"`   // 每页显示多少个服务器（默认10）
 	SortBy     string    `yaml:"sortBy"`     // 排序方式（name, host, disable）
@@ -41,9 +43,48 @@ type Config struct {
 	Password   string    `yaml:"password"`   // 全局的登录密码（可被服务器设置覆盖）
 	Servers    []*Server `yaml:"servers"`    // 远程服务器列表
 
-	Auth  ssh.AuthMethod `yaml:"-"`
-	Page  int            `yaml:"-"`
-	Group string         `yaml:"-"`
+	KnownHosts     string `yaml:"knownHosts"`     // known_hosts 文件路径（默认 ~/.ssh/known_hosts）
+	HashKnownHosts bool   `yaml:"hashKnownHosts"` // 写入 known_hosts 时是否哈希主机名
+	Agent          bool   `yaml:"agent"`          // 全局是否使用 ssh-agent 登录（可被服务器设置覆盖）
+	IdentityAgent  string `yaml:"identityAgent"`  // ssh-agent 的套接字路径（默认 $SSH_AUTH_SOCK）
+	ForwardAgent   bool   `yaml:"forwardAgent"`   // 全局是否把本地 ssh-agent 转发到远程服务器（可被服务器设置覆盖）
+
+	ServerAliveInterval int `yaml:"serverAliveInterval"` // 发送心跳的间隔秒数（为 0 时不发送）
+	ServerAliveCountMax int `yaml:"serverAliveCountMax"` // 连续多少次心跳无响应后断开连接（默认3）
+
+	ConnectTimeout int `yaml:"connectTimeout"` // 连接超时秒数（默认3）
+	ConnectRetries int `yaml:"connectRetries"` // 连接失败后的重试次数（默认0）
+	ControlPersist int `yaml:"controlPersist"` // 连接空闲多少秒后关闭，在此之前再次连接同一服务器时复用连接（默认0，不复用）
+
+	AutoReconnect    bool `yaml:"autoReconnect"`    // 网络断开后是否自动重新连接（可被服务器设置覆盖）
+	ReconnectRetries int  `yaml:"reconnectRetries"` // 自动重新连接的最大尝试次数（默认5）
+
+	PrivateKeys []string `yaml:"privateKeys"` // 全局的更多私钥路径（在 privateKey 之后按顺序尝试）
+	AuthMethods []string `yaml:"authMethods"` // 全局的登录方式及顺序（publickey, agent, keyboard-interactive, password）
+
+	PassphraseCommand string `yaml:"passphraseCommand"` // 获取私钥密码的命令（为空时在连接时询问）
+
+	Record      bool   `yaml:"record"`      // 全局是否以 asciicast v2 格式录制会话（可被分组和服务器设置覆盖）
+	RecordInput bool   `yaml:"recordInput"` // 录制时是否也录制输入（可能包含密码）
+	RecordDir   string `yaml:"recordDir"`   // 录制文件的目录（默认 ~/.j2/recordings）
+
+	LogSessions bool   `yaml:"logSessions"` // 全局是否把会话输出记录为纯文本日志（可被分组和服务器设置覆盖）
+	LogDir      string `yaml:"logDir"`      // 日志目录，每个服务器一个子目录，每天一个文件（默认 ~/.j2/logs）
+	LogMaxSize  int    `yaml:"logMaxSize"`  // 单个日志文件的最大 MB 数，超过后轮转（默认10，为负数时不限制）
+	LogMaxAge   int    `yaml:"logMaxAge"`   // 日志文件保留的天数（默认30，为负数时永久保留）
+
+	ImportSSHConfig bool   `yaml:"importSSHConfig"` // 加载时是否导入 ssh 配置文件中的服务器（同名时以本文件为准）
+	SSHConfigFile   string `yaml:"sshConfigFile"`   // ssh 配置文件路径（默认 ~/.ssh/config）
+	SSHConfigGroup  string `yaml:"sshConfigGroup"`  // 导入的服务器所属的分组（默认 ssh-config）
+
+	Env     map[string]string `yaml:"env"`     // 全局发送到远程会话的环境变量（可被分组和服务器设置覆盖）
+	SendEnv StringList        `yaml:"sendEnv"` // 全局转发到远程会话的本地环境变量（支持 * 和 ? 通配符，例如 LANG, LC_*）
+
+	Groups map[string]*Group `yaml:"groups"` // 分组设置（服务器设置优先，其次是分组设置，最后是全局设置）
+
+	Page  int    `yaml:"-"`
+	Group string `yaml:"-"`
+	File  string `yaml:"-"`
 }
 
 func NewConfig() *Config {
@@ -59,9 +100,88 @@ type Server struct {
 	Password   string `yaml:"password"`   // 登录密码（私钥登录优先，没有私钥则使用密码）
 	Desc       string `yaml:"desc"`       // 简短的描述
 	Group      string `yaml:"group"`      // 分组
+	HostKey    string `yaml:"hostKey"`    // 固定的主机公钥指纹（例如 SHA256:xxx，设置后不再查询 known_hosts）
+
+	Agent         *bool  `yaml:"agent"`         // 是否使用 ssh-agent 登录（为空时使用全局设置）
+	IdentityAgent string `yaml:"identityAgent"` // ssh-agent 的套接字路径（设置后总是使用 ssh-agent）
+	ForwardAgent  *bool  `yaml:"forwardAgent"`  // 是否把本地 ssh-agent 转发到远程服务器（为空时使用全局设置）
+
+	ServerAliveInterval int `yaml:"serverAliveInterval"` // 发送心跳的间隔秒数（为 0 时使用全局设置）
+	ServerAliveCountMax int `yaml:"serverAliveCountMax"` // 连续多少次心跳无响应后断开连接（为 0 时使用全局设置）
+
+	ConnectTimeout int  `yaml:"connectTimeout"` // 连接超时秒数（为 0 时使用全局设置）
+	ConnectRetries *int `yaml:"connectRetries"` // 连接失败后的重试次数（为空时使用全局设置，为 0 时不重试）
+	ControlPersist int  `yaml:"controlPersist"` // 连接空闲多少秒后关闭，为正数时复用连接（为 0 时使用全局设置，为负数时不复用）
+
+	AutoReconnect    *bool `yaml:"autoReconnect"`    // 网络断开后是否自动重新连接（为空时使用全局设置）
+	ReconnectRetries int   `yaml:"reconnectRetries"` // 自动重新连接的最大尝试次数（为 0 时使用全局设置）
+
+	PrivateKeys []string `yaml:"privateKeys"` // 更多的私钥路径（在 privateKey 之后按顺序尝试）
+	AuthMethods []string `yaml:"authMethods"` // 登录方式及顺序（为空时使用全局设置，都为空时自动选择）
 
-	Auth ssh.AuthMethod `yaml:"-"`
-	Addr string         `yaml:"-"`
+	PassphraseCommand string `yaml:"passphraseCommand"` // 获取私钥密码的命令（为空时使用全局设置）
+
+	Jump StringList `yaml:"jump"` // 跳板机列表（服务器名称或 user@host:port，按顺序连接）
+
+	LocalForwards  []*LocalForward  `yaml:"localForwards"`  // 登录后打开的本地端口转发
+	RemoteForwards []*RemoteForward `yaml:"remoteForwards"` // 登录后打开的远程端口转发
+	SocksPort      int              `yaml:"socksPort"`      // 登录后在本地打开的 SOCKS5 代理端口（为 0 时不打开）
+
+	Record      *bool  `yaml:"record"`      // 是否录制会话（为空时使用分组或全局设置）
+	RecordInput *bool  `yaml:"recordInput"` // 录制时是否也录制输入（为空时使用分组或全局设置）
+	RecordDir   string `yaml:"recordDir"`   // 录制文件的目录（为空时使用分组或全局设置）
+
+	LogSessions *bool `yaml:"logSessions"` // 是否把会话输出记录为纯文本日志（为空时使用分组或全局设置）
+
+	Env     map[string]string `yaml:"env"`     // 发送到远程会话的环境变量（与分组和全局设置合并，同名时此处优先）
+	SendEnv StringList        `yaml:"sendEnv"` // 转发到远程会话的本地环境变量（与分组和全局设置合并）
+
+	Workdir         string   `yaml:"workdir"`         // 登录后进入的工作目录（为空时使用分组设置）
+	StartupCommands []string `yaml:"startupCommands"` // 登录后在 shell 中执行的命令（为空时使用分组设置）
+	Become          string   `yaml:"become"`          // 登录后切换用户的方式（sudo 或 su，为空时使用分组设置）
+	BecomePassword  string   `yaml:"becomePassword"`  // sudo 或 su 的密码（为空时使用登录密码）
+
+	Auth []ssh.AuthMethod `yaml:"-"`
+	Addr string           `yaml:"-"`
+}
+
+// Group holds the settings shared by the servers of a group.
+type Group struct {
+	Record      *bool  `yaml:"record"`      // 是否录制会话（为空时使用全局设置）
+	RecordInput *bool  `yaml:"recordInput"` // 录制时是否也录制输入（为空时使用全局设置）
+	RecordDir   string `yaml:"recordDir"`   // 录制文件的目录（为空时使用全局设置）
+
+	LogSessions *bool `yaml:"logSessions"` // 是否把会话输出记录为纯文本日志（为空时使用全局设置）
+
+	Env     map[string]string `yaml:"env"`     // 发送到远程会话的环境变量（与全局设置合并，同名时此处优先）
+	SendEnv StringList        `yaml:"sendEnv"` // 转发到远程会话的本地环境变量（与全局设置合并）
+
+	Workdir         string   `yaml:"workdir"`         // 登录后进入的工作目录
+	StartupCommands []string `yaml:"startupCommands"` // 登录后在 shell 中执行的命令
+	Become          string   `yaml:"become"`          // 登录后切换用户的方式（sudo 或 su）
+	BecomePassword  string   `yaml:"becomePassword"`  // sudo 或 su 的密码（为空时使用登录密码）
+}
+
+// StringList can be written as a YAML list or a comma separated string.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*l = list
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
 }
 
 func (c *Config) Init() error {
@@ -83,7 +203,7 @@ func (c *Config) Init() error {
 			return c.from(ss[i])
 		}
 	}
-	return errors.New("no config file")
+	return ErrNoConfigFile
 }
 
 func (c *Config) NextPage() {
@@ -121,6 +241,19 @@ func (c *Config) AllList() []*Server {
 	return r
 }
 
+func (c *Config) Find(name string) (*Server, error) {
+	var found *Server
+	for i, j := 0, len(c.Servers); i < j; i++ {
+		if c.Servers[i].Name == name {
+			if found != nil {
+				return nil, fmt.Errorf("there is a remote server with the same name: %s", name)
+			}
+			found = c.Servers[i]
+		}
+	}
+	return found, nil
+}
+
 func (c *Config) PageList() []*Server {
 	var list []*Server
 	all := c.AllList()
@@ -170,6 +303,7 @@ func (c *Config) Summary(list []*Server) []string {
 	num := len(strconv.Itoa(len(list)))
 	format := fmt.Sprintf(" %%-%ds  %%-%ds  %%-%ds %%-%ds  %%-%ds  %%-%ds", num, counts[0], counts[1], counts[2], counts[3], counts[4])
 	prefix := color.New(color.FgHiGreen).Sprint(" **")
+	warning := color.New(color.FgHiRed).Sprint(" !!")
 	summary := make([]string, 0, len(list)+1)
 	summary = append(summary, "   "+color.New(color.FgYellow).Sprintf(format, "", "NAME", "USER", "HOST", "GROUP", "DESC"))
 	for i, j := 0, len(list); i < j; i++ {
@@ -179,7 +313,11 @@ func (c *Config) Summary(list []*Server) []string {
 			c.stuff(list[i].Name), c.stuff(list[i].User), c.stuff(list[i].Host),
 			c.stuff(list[i].Group), c.stuff(list[i].Desc),
 		}
-		summary = append(summary, prefix+color.New(color.FgCyan).Sprintf(format, args...))
+		if *list[i].ForwardAgent {
+			summary = append(summary, warning+color.New(color.FgCyan).Sprintf(format, args...))
+		} else {
+			summary = append(summary, prefix+color.New(color.FgCyan).Sprintf(format, args...))
+		}
 	}
 	return summary
 }
@@ -196,7 +334,14 @@ func (c *Config) ShowSummary() {
 	ShowTitle()
 
 	var n int
-	summary := c.Summary(c.PageList())
+	var forwardAgent bool
+	list := c.PageList()
+	for i, j := 0, len(list); i < j; i++ {
+		if *list[i].ForwardAgent {
+			forwardAgent = true
+		}
+	}
+	summary := c.Summary(list)
 	for i, j := 0, len(summary); i < j; i++ {
 		if nn := runewidth.StringWidth(summary[i]); nn > n {
 			n = nn
@@ -227,6 +372,15 @@ func (c *Config) ShowSummary() {
 	}
 
 	Echo(strings.Repeat(" ", 7) + color.YellowString("Page: %d/%d  Total: %d", c.Page, max, l))
+	if forwardAgent {
+		Echo(strings.Repeat(" ", 7) + color.HiRedString("!! ") + color.RedString("Agent forwarding is enabled, only connect to trusted servers."))
+	}
+	if n := len(Sessions()); n > 0 {
+		Echo(strings.Repeat(" ", 7) + color.YellowString("Detached sessions: %d (use -s to list them and -s <n> to resume)", n))
+	}
+	if ended := EndedSessions(); len(ended) > 0 {
+		Echo(strings.Repeat(" ", 7) + color.YellowString("Ended in the background: %s", strings.Join(ended, ", ")))
+	}
 }
 
 func (c *Config) exist(s string) bool {
@@ -237,6 +391,13 @@ func (c *Config) exist(s string) bool {
 	return info.Mode().IsRegular()
 }
 
+func (c *Config) path(s string) string {
+	if strings.HasPrefix(s, "~") {
+		return filepath.Join(os.Getenv("HOME"), s[1:])
+	}
+	return s
+}
+
 func (c *Config) from(s string) error {
 	data, err := ioutil.ReadFile(s)
 	if err != nil {
@@ -245,16 +406,53 @@ func (c *Config) from(s string) error {
 	if err = yaml.Unmarshal(data, c); err != nil {
 		return err
 	}
-	if auth, err := c.auth(c.PrivateKey, c.Password); err != nil {
-		return err
+	c.File = s
+	if c.ServerAliveCountMax <= 0 {
+		c.ServerAliveCountMax = 3
+	}
+	if c.ReconnectRetries <= 0 {
+		c.ReconnectRetries = 5
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = 3
+	}
+	if c.RecordDir == "" {
+		c.RecordDir = filepath.Join(os.Getenv("HOME"), ".j2", "recordings")
+	}
+	if c.LogDir == "" {
+		c.LogDir = filepath.Join(os.Getenv("HOME"), ".j2", "logs")
+	} else {
+		c.LogDir = c.path(c.LogDir)
+	}
+	if c.LogMaxSize == 0 {
+		c.LogMaxSize = 10
+	}
+	if c.LogMaxAge == 0 {
+		c.LogMaxAge = 30
+	}
+	if c.SSHConfigFile == "" {
+		c.SSHConfigFile = filepath.Join(os.Getenv("HOME"), ".ssh", "config")
 	} else {
-		c.Auth = auth
+		c.SSHConfigFile = c.path(c.SSHConfigFile)
+	}
+	if c.SSHConfigGroup == "" {
+		c.SSHConfigGroup = "ssh-config"
+	}
+	if c.ImportSSHConfig {
+		if err = c.importSSHConfig(); err != nil {
+			return err
+		}
 	}
 	for i, j := 0, len(c.Servers); i < j; i++ {
 		if err = c.init(c.Servers[i]); err != nil {
 			return err
 		}
 	}
+	if c.KnownHosts == "" {
+		c.KnownHosts = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	} else {
+		c.KnownHosts = c.path(c.KnownHosts)
+	}
 	if c.PageSize <= 5 {
 		c.PageSize = 5
 	}
@@ -262,18 +460,68 @@ func (c *Config) from(s string) error {
 	return nil
 }
 
+// The servers of the config file take precedence over the imported ones with the same name.
+func (c *Config) importSSHConfig() error {
+	servers, err := LoadSSHConfig(c.SSHConfigFile, c.SSHConfigGroup)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for i, j := 0, len(servers); i < j; i++ {
+		if found, err := c.Find(servers[i].Name); found == nil && err == nil {
+			c.Servers = append(c.Servers, servers[i])
+		}
+	}
+	return nil
+}
+
 func (c *Config) init(s *Server) error {
 	if s.Host == "" {
 		return fmt.Errorf("the server host can not be empty")
 	}
-	if s.PrivateKey == "" && s.Password == "" {
-		s.Auth = c.Auth
+	if s.PrivateKey == "" && len(s.PrivateKeys) == 0 && s.Password == "" {
+		s.PrivateKey, s.PrivateKeys, s.Password = c.PrivateKey, c.PrivateKeys, c.Password
+	}
+	if s.IdentityAgent != "" {
+		agent := true
+		s.Agent = &agent
 	} else {
-		if auth, err := c.auth(s.PrivateKey, s.Password); err != nil {
-			return err
-		} else {
-			s.Auth = auth
-		}
+		s.IdentityAgent = c.IdentityAgent
+	}
+	if s.Agent == nil {
+		s.Agent = &c.Agent
+	}
+	if s.ForwardAgent == nil {
+		s.ForwardAgent = &c.ForwardAgent
+	}
+	if s.ServerAliveInterval == 0 {
+		s.ServerAliveInterval = c.ServerAliveInterval
+	}
+	if s.ServerAliveCountMax == 0 {
+		s.ServerAliveCountMax = c.ServerAliveCountMax
+	}
+	if s.ConnectTimeout <= 0 {
+		s.ConnectTimeout = c.ConnectTimeout
+	}
+	if s.ConnectRetries == nil {
+		s.ConnectRetries = &c.ConnectRetries
+	}
+	if s.ControlPersist == 0 {
+		s.ControlPersist = c.ControlPersist
+	}
+	if s.AutoReconnect == nil {
+		s.AutoReconnect = &c.AutoReconnect
+	}
+	if s.ReconnectRetries == 0 {
+		s.ReconnectRetries = c.ReconnectRetries
+	}
+	if len(s.AuthMethods) == 0 {
+		s.AuthMethods = c.AuthMethods
+	}
+	if s.PassphraseCommand == "" {
+		s.PassphraseCommand = c.PassphraseCommand
 	}
 	if s.Port == 0 {
 		s.Port = 22
@@ -284,7 +532,77 @@ func (c *Config) init(s *Server) error {
 	if s.Group == "" {
 		s.Group = "default"
 	}
+	g := c.Groups[s.Group]
+	if g == nil {
+		g = new(Group)
+	}
+	if s.Record == nil {
+		if s.Record = g.Record; s.Record == nil {
+			s.Record = &c.Record
+		}
+	}
+	if s.RecordInput == nil {
+		if s.RecordInput = g.RecordInput; s.RecordInput == nil {
+			s.RecordInput = &c.RecordInput
+		}
+	}
+	if s.RecordDir == "" {
+		if s.RecordDir = g.RecordDir; s.RecordDir == "" {
+			s.RecordDir = c.RecordDir
+		}
+	}
+	s.RecordDir = c.path(s.RecordDir)
+	if s.LogSessions == nil {
+		if s.LogSessions = g.LogSessions; s.LogSessions == nil {
+			s.LogSessions = &c.LogSessions
+		}
+	}
+	if len(c.Env) > 0 || len(g.Env) > 0 {
+		env := make(map[string]string, len(c.Env)+len(g.Env)+len(s.Env))
+		for _, m := range []map[string]string{c.Env, g.Env, s.Env} {
+			for k, v := range m {
+				env[k] = v
+			}
+		}
+		s.Env = env
+	}
+	if len(c.SendEnv) > 0 || len(g.SendEnv) > 0 {
+		s.SendEnv = append(append(append(StringList(nil), c.SendEnv...), g.SendEnv...), s.SendEnv...)
+	}
+	if s.Workdir == "" {
+		s.Workdir = g.Workdir
+	}
+	if len(s.StartupCommands) == 0 {
+		s.StartupCommands = g.StartupCommands
+	}
+	if s.Become == "" {
+		s.Become = g.Become
+	}
+	if s.BecomePassword == "" {
+		s.BecomePassword = g.BecomePassword
+	}
+	if s.Become != "" && s.Become != "sudo" && s.Become != "su" {
+		return fmt.Errorf("server %s: invalid become method: %s", s.Name, s.Become)
+	}
 	s.Addr = net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	for i, j := 0, len(s.LocalForwards); i < j; i++ {
+		if err := s.LocalForwards[i].init(); err != nil {
+			return fmt.Errorf("server %s: %s", s.Name, err)
+		}
+	}
+	for i, j := 0, len(s.RemoteForwards); i < j; i++ {
+		if err := s.RemoteForwards[i].init(); err != nil {
+			return fmt.Errorf("server %s: %s", s.Name, err)
+		}
+	}
+	if s.SocksPort < 0 || s.SocksPort > 65535 {
+		return fmt.Errorf("server %s: invalid socks port: %d", s.Name, s.SocksPort)
+	}
+	if auth, err := c.auth(s); err != nil {
+		return err
+	} else {
+		s.Auth = auth
+	}
 	return nil
 }
 
@@ -313,21 +631,3 @@ func (c *Config) sort() {
 		})
 	}
 }
-
-func (c *Config) auth(key, pass string) (ssh.AuthMethod, error) {
-	if key == "" {
-		return ssh.Password(pass), nil
-	}
-	if strings.HasPrefix(key, "~") {
-		key = filepath.Join(os.Getenv("HOME"), key[1:])
-	}
-	data, err := ioutil.ReadFile(key)
-	if err != nil {
-		return nil, err
-	}
-	signer, err := ssh.ParsePrivateKey(data)
-	if err != nil {
-		return nil, err
-	}
-	return ssh.PublicKeys(signer), nil
-}
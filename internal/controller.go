@@ -15,6 +15,7 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -36,6 +37,11 @@ var CommandSuggests = []prompt.Suggest{
 	{Text: "-n", Description: "Displays the next page of the server list."},
 	{Text: "-p", Description: "Displays the previous page of the server list."},
 	{Text: "-g", Description: "Set the group for the server list."},
+	{Text: "-put", Description: "Upload the local file or directory: -put <local> <name>:<remote>."},
+	{Text: "-get", Description: "Download the remote file or directory: -get <name>:<remote> <local>."},
+	{Text: "-f", Description: "Browse the files of the server: -f <name>."},
+	{Text: "-s", Description: "List the detached sessions, or resume one: -s <n>."},
+	{Text: "-kill", Description: "Close the detached session: -kill <n>."},
 	{Text: "-h", Description: "Display the usage guide of J2."},
 	{Text: "-exit", Description: "Exit J2."},
 }
@@ -78,6 +84,12 @@ func Completer(doc prompt.Document) []prompt.Suggest {
 			}
 			return prompt.FilterFuzzy(suggests, word, true)
 		}
+		if strings.HasPrefix(text, "-f ") {
+			return prompt.FilterFuzzy(serverSuggests(Cfg.Servers), word, true)
+		}
+		if strings.HasPrefix(text, "-s ") || strings.HasPrefix(text, "-kill ") {
+			return prompt.FilterHasPrefix(sessionSuggests(), word, true)
+		}
 		if strings.HasSuffix(text, " ") {
 			return nil
 		}
@@ -86,17 +98,10 @@ func Completer(doc prompt.Document) []prompt.Suggest {
 	if len(word) == 0 {
 		return nil
 	}
-	list := Cfg.AllList()
-	if len(list) == 0 {
+	suggests := serverSuggests(Cfg.AllList())
+	if len(suggests) == 0 {
 		return nil
 	}
-	suggests := make([]prompt.Suggest, 0, len(list))
-	for i, j := 0, len(list); i < j; i++ {
-		suggests = append(suggests, prompt.Suggest{
-			Text:        list[i].Name,
-			Description: list[i].Desc,
-		})
-	}
 	if prefixed := prompt.FilterHasPrefix(suggests, word, true); len(prefixed) == 0 {
 		num, _ := strconv.Atoi(word)
 		if num > 0 && num <= len(suggests) {
@@ -106,6 +111,17 @@ func Completer(doc prompt.Document) []prompt.Suggest {
 	return prompt.FilterFuzzy(suggests, word, true)
 }
 
+func serverSuggests(list []*Server) []prompt.Suggest {
+	suggests := make([]prompt.Suggest, 0, len(list))
+	for i, j := 0, len(list); i < j; i++ {
+		suggests = append(suggests, prompt.Suggest{
+			Text:        list[i].Name,
+			Description: list[i].Desc,
+		})
+	}
+	return suggests
+}
+
 func Executor(input string) {
 	if input == "" {
 		Cfg.ShowSummary()
@@ -120,6 +136,55 @@ func Executor(input string) {
 	case text == "-p":
 		Cfg.PrevPage()
 		Cfg.ShowSummary()
+	case text == "-put" || strings.HasPrefix(text, "-put "):
+		args := strings.Fields(text[4:])
+		if len(args) != 2 {
+			Error("Usage: -put <local> <name>:<remote>")
+			return
+		}
+		if err := Put(args[0], args[1]); err != nil {
+			Error("Upload %s error: %s", args[0], err)
+		}
+	case text == "-get" || strings.HasPrefix(text, "-get "):
+		args := strings.Fields(text[4:])
+		if len(args) != 2 {
+			Error("Usage: -get <name>:<remote> <local>")
+			return
+		}
+		if err := Get(args[0], args[1]); err != nil {
+			Error("Download %s error: %s", args[0], err)
+		}
+	case text == "-f" || strings.HasPrefix(text, "-f "):
+		name := strings.TrimSpace(text[2:])
+		if name == "" {
+			Error("Usage: -f <name>")
+			return
+		}
+		server, err := findServer(name)
+		if err == nil {
+			err = Browse(server)
+		}
+		Cfg.ShowSummary()
+		if err != nil {
+			Error("Browse server %s error: %s", name, err)
+		}
+	case text == "-s":
+		ShowSessions()
+	case strings.HasPrefix(text, "-s "):
+		sess, err := findSession(text[3:])
+		if err != nil {
+			Error("%s", err)
+			return
+		}
+		handle(sess.Server, sess.Attach())
+	case text == "-kill" || strings.HasPrefix(text, "-kill "):
+		sess, err := findSession(text[5:])
+		if err != nil {
+			Error("%s", err)
+			return
+		}
+		sess.Kill()
+		Echo(color.GreenString("Session #%d of server %s is closed.", sess.ID, sess.Server.Name))
 	case strings.HasPrefix(text, "-g"):
 		group := strings.TrimSpace(text[2:])
 		Cfg.Group = group
@@ -155,90 +220,37 @@ func Executor(input string) {
 			Error("Instruction %q is invalid. Please use -h to view the usage guide.", input)
 			return
 		}
-		err := Connect(server)
-		Cfg.ShowSummary()
-		if err != nil {
-			Error("Handle server %s error: %s", server.Name, err)
-		}
+		handle(server, Connect(server))
 	}
 }
 
-func Connect(s *Server) error {
-	client, err := ssh.Dial("tcp", s.Addr, &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            []ssh.AuthMethod{s.Auth},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Second * 3,
-	})
-	if err != nil {
-		return err
-	}
-	defer doClose(client)
-
-	sess, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer doClose(sess)
-
-	in := int(os.Stdin.Fd())
-	width, height, err := terminal.GetSize(in)
-	if err != nil {
-		return err
-	}
-	state, err := terminal.MakeRaw(in)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = terminal.Restore(in, state) }()
-
-	stdin, _ := sess.StdinPipe()
-
-	sess.Stdout = os.Stdout
-	sess.Stderr = os.Stderr
-
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
-	}
-	term := os.Getenv("TERM")
-	if term == "" {
-		term = "xterm-256color"
+// The handle function reconnects the server if the connection of the session
+// is lost, and shows the error of the session.
+func handle(s *Server, err error) {
+	if *s.AutoReconnect {
+		var lost *ConnectionLostError
+		if errors.As(err, &lost) {
+			err = Reconnect(s, lost)
+		}
 	}
-	err = sess.RequestPty(term, height, width, modes)
+	Cfg.ShowSummary()
 	if err != nil {
-		return err
-	}
-	if err = sess.Shell(); err != nil {
-		return err
+		Error("Handle server %s error: %s", s.Name, err)
 	}
+}
 
-	exit := make(chan struct{})
-
-	wg := new(sync.WaitGroup)
-	wg.Add(2)
-
-	go loop(wg, exit, in, stdin)
-	go resize(wg, exit, sess, in)
-
-	err = sess.Wait()
-	close(exit)
-	wg.Wait()
-
+// Connect opens a new session of the server and attaches it.
+func Connect(s *Server) error {
+	sess, err := OpenSession(s)
 	if err != nil {
-		switch err.(type) {
-		case *ssh.ExitMissingError:
-			return nil
-		case *ssh.ExitError:
-			return nil
-		}
 		return err
 	}
-	return nil
+	return sess.Attach()
 }
 
-func loop(wg *sync.WaitGroup, exit chan struct{}, r int, w io.WriteCloser) {
+// The loop function copies the input to the writer, the translate function
+// (if any) processes the input first, and false stops the loop.
+func loop(wg *sync.WaitGroup, exit chan struct{}, r int, w io.WriteCloser, translate func([]byte) ([]byte, bool)) {
 	defer wg.Done()
 
 	buf := make([]byte, 1024)
@@ -249,15 +261,28 @@ func loop(wg *sync.WaitGroup, exit chan struct{}, r int, w io.WriteCloser) {
 			return
 		default:
 		}
+		if ok, err := waitInput(r, time.Millisecond*100); err != nil {
+			Error("Wait input error: %s", err)
+			return
+		} else if !ok {
+			continue
+		}
 		if n, err := syscall.Read(r, buf); err != nil {
+			if err == syscall.EINTR || err == syscall.EAGAIN {
+				continue
+			}
 			Error("Read input error: %s", err)
+			return
 		} else {
 			select {
 			case <-exit:
 				return
 			default:
 			}
-			token := translate(buf[:n])
+			token, ok := buf[:n], true
+			if translate != nil {
+				token, ok = translate(token)
+			}
 			if len(token) > 0 {
 				_, err = w.Write(token)
 				if err != nil {
@@ -267,13 +292,16 @@ func loop(wg *sync.WaitGroup, exit chan struct{}, r int, w io.WriteCloser) {
 					Error("Write error: %s", err)
 				}
 			}
+			if !ok {
+				return
+			}
 		}
 	}
 }
 
-func resize(wg *sync.WaitGroup, exit chan struct{}, sess *ssh.Session, in int) {
+func resize(wg *sync.WaitGroup, exit chan struct{}, sess *ssh.Session, in int, rec *Recorder) {
 	defer wg.Done()
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGWINCH)
 	defer signal.Stop(ch)
 	for {
@@ -281,6 +309,9 @@ func resize(wg *sync.WaitGroup, exit chan struct{}, sess *ssh.Session, in int) {
 		case <-ch:
 			if width, height, err := terminal.GetSize(in); err == nil {
 				_ = sess.WindowChange(height, width)
+				if rec != nil {
+					rec.Resize(width, height)
+				}
 			}
 		case <-exit:
 			return
@@ -288,10 +319,6 @@ func resize(wg *sync.WaitGroup, exit chan struct{}, sess *ssh.Session, in int) {
 	}
 }
 
-func translate(token []byte) []byte {
-	return token
-}
-
 func doClose(c io.Closer) {
 	_ = c.Close()
 }
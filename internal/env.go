@@ -0,0 +1,72 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The environ function returns the environment variables sent to the server,
+// the variables set in the config take precedence over the local ones.
+func environ(s *Server) map[string]string {
+	env := make(map[string]string)
+	if len(s.SendEnv) > 0 {
+		vars := os.Environ()
+		for i, j := 0, len(vars); i < j; i++ {
+			n := strings.IndexByte(vars[i], '=')
+			if n <= 0 {
+				continue
+			}
+			for k, l := 0, len(s.SendEnv); k < l; k++ {
+				if matchPattern(s.SendEnv[k], vars[i][:n]) {
+					env[vars[i][:n]] = vars[i][n+1:]
+					break
+				}
+			}
+		}
+	}
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	return env
+}
+
+// The setenv function sends the environment variables before the shell or the
+// command starts, the server only accepts the variables allowed by AcceptEnv.
+func setenv(sess *ssh.Session, s *Server) {
+	env := environ(s)
+	if len(env) == 0 {
+		return
+	}
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var rejected []string
+	for i, j := 0, len(names); i < j; i++ {
+		if err := sess.Setenv(names[i], env[names[i]]); err != nil {
+			rejected = append(rejected, names[i])
+		}
+	}
+	if len(rejected) > 0 {
+		Warn("Server %s rejected the environment variable(s) %s, check AcceptEnv in its sshd_config.", s.Name, strings.Join(rejected, ", "))
+	}
+}
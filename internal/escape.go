@@ -0,0 +1,228 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var EscapeHelp = []string{
+	"Supported escape sequences:",
+	" ~.   - terminate connection",
+	" ~^Z  - detach the session and return to the server list",
+	" ~#   - list forwarded connections",
+	" ~C   - open a command line",
+	" ~?   - this message",
+	" ~~   - send the escape character by typing it twice",
+	"(Note that escapes are only recognized immediately after newline.)",
+}
+
+var EscapeCommandHelp = []string{
+	"Commands:",
+	"      -L[bind_address:]port:host:hostport    Request local forward",
+	"      -R[bind_address:]port:host:hostport    Request remote forward",
+	"      -D[bind_address:]port                  Request dynamic forward",
+	"      -KL[bind_address:]port                 Cancel local forward",
+	"      -KR[bind_address:]port                 Cancel remote forward",
+	"      -KD[bind_address:]port                 Cancel dynamic forward",
+}
+
+// The translate function handles the escape sequences of the input, like OpenSSH
+// the escape character ~ is only recognized at the beginning of a line. It returns
+// the input sent to the server and false if the input should not be read anymore.
+func (r *Session) translate(token []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(token)+1)
+	for i, j := 0, len(token); i < j; i++ {
+		c := token[i]
+		if r.escape {
+			r.escape = false
+			switch c {
+			case '.':
+				Echo("\r\n" + color.YellowString("Connection to %s closed.", r.Server.Name))
+				r.Disconnect()
+				return out, false
+			case 0x1a: // Control+Z
+				close(r.detach)
+				return out, false
+			case '?':
+				Echo("")
+				for k, l := 0, len(EscapeHelp); k < l; k++ {
+					Echo(EscapeHelp[k])
+				}
+			case '#':
+				Echo("")
+				Echo("The following forwards are open:")
+				list := r.forwarder.List()
+				for k, l := 0, len(list); k < l; k++ {
+					Echo("  %s", list[k])
+				}
+			case 'C':
+				r.commandLine()
+			case '~':
+				out = append(out, c)
+				r.newline = false
+			default:
+				out = append(out, '~', c)
+				r.newline = c == '\r' || c == '\n'
+			}
+			continue
+		}
+		if r.newline && c == '~' {
+			r.escape = true
+			continue
+		}
+		out = append(out, c)
+		r.newline = c == '\r' || c == '\n'
+	}
+	return out, true
+}
+
+// The commandLine function reads a command in the cooked mode of the terminal,
+// the output of the session is kept until the command is done.
+func (r *Session) commandLine() {
+	r.output.detach()
+	_ = terminal.Restore(r.in, r.state)
+	defer func() {
+		_, _ = terminal.MakeRaw(r.in)
+		r.output.attach(os.Stdout)
+	}()
+
+	Echo("")
+	line, err := Ask("ssh> ")
+	if err != nil {
+		Error("Read command error: %s", err)
+		return
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return
+	}
+	if err = r.command(line); err != nil {
+		Error("%s", err)
+	}
+}
+
+func (r *Session) command(line string) error {
+	if line == "?" || line == "-h" || line == "help" {
+		for i, j := 0, len(EscapeCommandHelp); i < j; i++ {
+			Echo(EscapeCommandHelp[i])
+		}
+		return nil
+	}
+	if len(line) < 2 || line[0] != '-' {
+		return fmt.Errorf("invalid command %q, use ? for help", line)
+	}
+	cancel := line[1] == 'K'
+	if cancel {
+		line = line[1:]
+	}
+	if len(line) < 2 {
+		return fmt.Errorf("invalid command, use ? for help")
+	}
+	kind, args := strings.ToUpper(line[1:2]), splitForwardSpec(strings.TrimSpace(line[2:]))
+	if kind != "L" && kind != "R" && kind != "D" {
+		return fmt.Errorf("invalid command %q, use ? for help", line)
+	}
+
+	if cancel || kind == "D" {
+		var bind string
+		switch len(args) {
+		case 1:
+		case 2:
+			bind, args = args[0], args[1:]
+		default:
+			return fmt.Errorf("bad forwarding specification")
+		}
+		port, err := strconv.Atoi(args[0])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("bad forwarding port %q", args[0])
+		}
+		if cancel {
+			if err = r.forwarder.Cancel(kind, bind, port); err != nil {
+				return err
+			}
+			Echo(color.GreenString("Canceled forwarding."))
+			return nil
+		}
+		if bind == "" {
+			bind = "127.0.0.1"
+		}
+		if _, err = r.forwarder.Dynamic(bind, port); err != nil {
+			return err
+		}
+		Echo(color.GreenString("Forwarding port."))
+		return nil
+	}
+
+	bind := "127.0.0.1"
+	switch len(args) {
+	case 3:
+	case 4:
+		bind, args = args[0], args[1:]
+	default:
+		return fmt.Errorf("bad forwarding specification")
+	}
+	port, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("bad forwarding port %q", args[0])
+	}
+	hostPort, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("bad forwarding port %q", args[2])
+	}
+	if kind == "L" {
+		lf := &LocalForward{Bind: bind, LocalPort: port, RemoteHost: args[1], RemotePort: hostPort}
+		if err = lf.init(); err == nil {
+			err = r.forwarder.Local(lf)
+		}
+	} else {
+		rf := &RemoteForward{Bind: bind, RemotePort: port, LocalHost: args[1], LocalPort: hostPort}
+		if err = rf.init(); err == nil {
+			err = r.forwarder.Remote(rf)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	Echo(color.GreenString("Forwarding port."))
+	return nil
+}
+
+// The splitForwardSpec function splits the specification by colons, the IPv6
+// addresses must be enclosed in square brackets.
+func splitForwardSpec(spec string) []string {
+	var parts []string
+	var part strings.Builder
+	var bracket bool
+	for i, j := 0, len(spec); i < j; i++ {
+		switch c := spec[i]; {
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case c == ':' && !bracket:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, part.String())
+}
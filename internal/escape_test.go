@@ -0,0 +1,200 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func newEscapeTestSession(t *testing.T) *Session {
+	srv := newSSHTestServer(t)
+	s := srv.server(t, "test")
+	newTestConfig(t, s)
+	client, err := Dial(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Session{
+		Server:    s,
+		client:    client,
+		sess:      sess,
+		forwarder: NewForwarder(client.Client),
+		output:    &sessionOutput{},
+		done:      make(chan struct{}),
+		state:     &terminal.State{},
+		detach:    make(chan struct{}),
+		newline:   true,
+	}
+	t.Cleanup(func() {
+		close(r.done)
+		r.forwarder.Close()
+		doClose(client)
+	})
+	return r
+}
+
+// The withStdin function runs the function with a stdin which reads the input.
+func withStdin(t *testing.T, input string, f func()) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doClose(pr)
+	if _, err = pw.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	doClose(pw)
+	defer func(old *os.File) { os.Stdin = old }(os.Stdin)
+	os.Stdin = pr
+	f()
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doClose(ln)
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		out    string
+		ok     bool
+		closed bool
+		detach bool
+	}{
+		{"plain", []string{"ls -l\r"}, "ls -l\r", true, false, false},
+		{"disconnect", []string{"~."}, "", false, true, false},
+		{"disconnect after newline", []string{"ls\r~.whoami\r"}, "ls\r", false, true, false},
+		{"detach", []string{"~\x1a"}, "", false, false, true},
+		{"forwards", []string{"~#"}, "", true, false, false},
+		{"help", []string{"~?"}, "", true, false, false},
+		{"command line", []string{"~C"}, "", true, false, false},
+		{"escape character", []string{"~~"}, "~", true, false, false},
+		{"escape character twice", []string{"~~~~"}, "~~~", true, false, false},
+		{"unknown escape", []string{"~x"}, "~x", true, false, false},
+		{"escape before newline", []string{"~\r~."}, "~\r", false, true, false},
+		{"middle of line", []string{"a~.b~?~~"}, "a~.b~?~~", true, false, false},
+		{"after newline", []string{"a\n~~"}, "a\n~", true, false, false},
+		{"split tokens", []string{"ls\r~", "."}, "ls\r", false, true, false},
+		{"split escape character", []string{"~", "~x"}, "~x", true, false, false},
+		{"split middle of line", []string{"a", "~."}, "a~.", true, false, false},
+	}
+	for _, tt := range tests {
+		r := newEscapeTestSession(t)
+		var out []byte
+		ok := true
+		withStdin(t, "?\n", func() {
+			r.in = int(os.Stdin.Fd())
+			for i, j := 0, len(tt.tokens); i < j && ok; i++ {
+				var b []byte
+				b, ok = r.translate([]byte(tt.tokens[i]))
+				out = append(out, b...)
+			}
+		})
+		if string(out) != tt.out || ok != tt.ok {
+			t.Errorf("%s: got %q and %t", tt.name, out, ok)
+		}
+		if r.closed != tt.closed {
+			t.Errorf("%s: closed %t", tt.name, r.closed)
+		}
+		select {
+		case <-r.detach:
+			if !tt.detach {
+				t.Errorf("%s: detached", tt.name)
+			}
+		default:
+			if tt.detach {
+				t.Errorf("%s: not detached", tt.name)
+			}
+		}
+	}
+}
+
+func TestSplitForwardSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"8080", []string{"8080"}},
+		{"8080:db:5432", []string{"8080", "db", "5432"}},
+		{"0.0.0.0:8080:db:5432", []string{"0.0.0.0", "8080", "db", "5432"}},
+		{"[::1]:8080", []string{"::1", "8080"}},
+		{"8080:[fe80::1]:22", []string{"8080", "fe80::1", "22"}},
+		{"[::]:8080:[2001:db8::1]:5432", []string{"::", "8080", "2001:db8::1", "5432"}},
+		{"", []string{""}},
+	}
+	for _, tt := range tests {
+		if got := splitForwardSpec(tt.spec); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q", tt.spec, got)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	r := newEscapeTestSession(t)
+	local, dynamic, other := freePort(t), freePort(t), freePort(t)
+	steps := []struct {
+		line string
+		err  string
+		list []string
+	}{
+		{"?", "", nil},
+		{"-L" + strconv.Itoa(local) + ":db:5432", "", []string{"L 127.0.0.1:" + strconv.Itoa(local) + " -> db:5432"}},
+		{"-KL 0.0.0.0:" + strconv.Itoa(local), "no L forward", nil},
+		{"-KL 127.0.0.1:" + strconv.Itoa(local), "", []string{}},
+		{"-L [127.0.0.1]:" + strconv.Itoa(local) + ":[::1]:22", "", []string{"L 127.0.0.1:" + strconv.Itoa(local) + " -> [::1]:22"}},
+		{"-KL" + strconv.Itoa(local), "", []string{}},
+		{"-D " + strconv.Itoa(dynamic), "", nil},
+		{"-d127.0.0.1:" + strconv.Itoa(other), "", nil},
+		{"-KD 127.0.0.1:" + strconv.Itoa(dynamic), "", nil},
+		{"-KD" + strconv.Itoa(other), "", []string{}},
+		{"-KR " + strconv.Itoa(other), "no R forward", nil},
+		{"-KD " + strconv.Itoa(other), "no D forward", nil},
+		{"-KD a:b:1", "bad forwarding specification", nil},
+		{"-KL port", "bad forwarding port", nil},
+		{"-D 70000", "bad forwarding port", nil},
+		{"-L8080:db", "bad forwarding specification", nil},
+		{"-R8080:db:port", "bad forwarding port", nil},
+		{"-L0:db:5432", "invalid local forward port", nil},
+		{"-X8080", "invalid command", nil},
+		{"-K", "invalid command", nil},
+		{"ls", "invalid command", nil},
+	}
+	for _, step := range steps {
+		err := r.command(step.line)
+		if step.err == "" && err != nil || step.err != "" && (err == nil || !strings.Contains(err.Error(), step.err)) {
+			t.Fatalf("%q: got %v", step.line, err)
+		}
+		if list := r.forwarder.List(); step.list != nil && !reflect.DeepEqual(list, step.list) {
+			t.Fatalf("%q: got %q", step.line, list)
+		}
+	}
+}
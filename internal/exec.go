@@ -0,0 +1,163 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The exit status used when the remote command did not report one, like OpenSSH.
+const ExitStatusUnknown = 255
+
+func RunExec(args []string) int {
+	flags := newFlagSet("exec", "[-t] <name> -- <command> | -g <group> [-c num] [-o dir] -- <command>")
+	tty := flags.Bool("t", false, "Force a pseudo terminal for the command.")
+	group := flags.String("g", "", "Run the command on all servers of the group.")
+	concurrency := flags.Int("c", 10, "The maximum number of servers to run the command on at the same time.")
+	dir := flags.String("o", "", "Also write the output of each server to <dir>/<name>.log.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if *group != "" {
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) == 0 || *tty || *concurrency < 1 {
+			flags.Usage()
+			return 2
+		}
+		return RunGroup(*group, strings.Join(args, " "), *concurrency, *dir)
+	}
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		flags.Usage()
+		return 2
+	}
+	s, err := findServer(args[0])
+	if err != nil {
+		Error("%s", err)
+		return 1
+	}
+	command := strings.Join(args[1:], " ")
+
+	client, err := Dial(s)
+	if err != nil {
+		Error("Connect to server %s error: %s", s.Name, err)
+		return ExitStatusUnknown
+	}
+	defer doClose(client)
+
+	code, err := Execute(client.Client, s, command, *tty, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		Error("Execute command on server %s error: %s", s.Name, err)
+	}
+	return code
+}
+
+// The Execute function runs the command on the server and returns its exit status.
+// The stdin is only sent to the command when it is not a terminal or a pseudo
+// terminal is requested, otherwise the command would wait for the input forever.
+func Execute(client *ssh.Client, s *Server, command string, tty bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return ExitStatusUnknown, err
+	}
+	defer doClose(sess)
+
+	done := make(chan struct{})
+	defer close(done)
+	dead := keepalive(client, time.Duration(s.ServerAliveInterval)*time.Second, s.ServerAliveCountMax, done)
+
+	setenv(sess, s)
+
+	sess.Stdout = stdout
+	sess.Stderr = stderr
+
+	in := -1
+	if f, ok := stdin.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		in = int(f.Fd())
+	} else {
+		sess.Stdin = stdin
+	}
+
+	exit := make(chan struct{})
+	wg := new(sync.WaitGroup)
+
+	if tty {
+		width, height := 80, 24
+		if in >= 0 {
+			if w, h, err := terminal.GetSize(in); err == nil {
+				width, height = w, h
+			}
+		}
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "xterm-256color"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err = sess.RequestPty(term, height, width, modes); err != nil {
+			return ExitStatusUnknown, err
+		}
+		if in >= 0 {
+			state, err := terminal.MakeRaw(in)
+			if err != nil {
+				return ExitStatusUnknown, err
+			}
+			defer func() { _ = terminal.Restore(in, state) }()
+
+			w, err := sess.StdinPipe()
+			if err != nil {
+				return ExitStatusUnknown, err
+			}
+			wg.Add(2)
+			go loop(wg, exit, in, w, nil)
+			go resize(wg, exit, sess, in, nil)
+		}
+	}
+
+	if err = sess.Start(command); err != nil {
+		return ExitStatusUnknown, err
+	}
+	err = sess.Wait()
+	close(exit)
+	wg.Wait()
+
+	select {
+	case err = <-dead:
+		return ExitStatusUnknown, err
+	default:
+	}
+	if err != nil {
+		if e, ok := err.(*ssh.ExitError); ok {
+			return e.ExitStatus(), nil
+		}
+		return ExitStatusUnknown, err
+	}
+	return 0, nil
+}
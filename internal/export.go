@@ -0,0 +1,224 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The exported servers never contain the passwords.
+type exportedServer struct {
+	Name        string   `json:"name"`
+	User        string   `json:"user"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Group       string   `json:"group"`
+	Desc        string   `json:"desc"`
+	PrivateKeys []string `json:"privateKeys"`
+	Jump        []string `json:"jump"`
+}
+
+var Exporters = map[string]func(w io.Writer, list []*Server) error{
+	"ssh-config": exportSSHConfig,
+	"json":       exportJSON,
+	"csv":        exportCSV,
+	"ansible":    exportAnsible,
+}
+
+func RunExport(args []string) int {
+	flags := newFlagSet("export", "[--format ssh-config|json|csv|ansible] [-g group]")
+	format := flags.String("format", "ssh-config", "The output format: ssh-config, json, csv or ansible.")
+	group := flags.String("g", "", "Only export the servers of the group.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	export := Exporters[*format]
+	if export == nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	list := Cfg.Servers
+	if *group != "" {
+		list = Cfg.GroupList(*group)
+	}
+
+	var buf bytes.Buffer
+	if err := export(&buf, list); err != nil {
+		Error("Export servers error: %s", err)
+		return 1
+	}
+	if _, err := buf.WriteTo(os.Stdout); err != nil {
+		Error("Export servers error: %s", err)
+		return 1
+	}
+	return 0
+}
+
+func exportServers(list []*Server) []*exportedServer {
+	r := make([]*exportedServer, 0, len(list))
+	for i, j := 0, len(list); i < j; i++ {
+		r = append(r, &exportedServer{
+			Name:        list[i].Name,
+			User:        list[i].User,
+			Host:        list[i].Host,
+			Port:        list[i].Port,
+			Group:       list[i].Group,
+			Desc:        list[i].Desc,
+			PrivateKeys: append([]string{}, list[i].keys()...),
+			Jump:        append([]string{}, list[i].Jump...),
+		})
+	}
+	return r
+}
+
+func exportJSON(w io.Writer, list []*Server) error {
+	data, err := json.MarshalIndent(exportServers(list), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func exportCSV(w io.Writer, list []*Server) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"name", "user", "host", "port", "group", "desc", "privateKeys", "jump"}); err != nil {
+		return err
+	}
+	servers := exportServers(list)
+	for i, j := 0, len(servers); i < j; i++ {
+		s := servers[i]
+		record := []string{
+			s.Name, s.User, s.Host, strconv.Itoa(s.Port), s.Group, s.Desc,
+			strings.Join(s.PrivateKeys, ";"), strings.Join(s.Jump, ";"),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// The names of the servers are the host aliases, so the jump hosts which are
+// other servers can be used by name.
+func exportSSHConfig(w io.Writer, list []*Server) error {
+	for i, j := 0, len(list); i < j; i++ {
+		s := list[i]
+		var b strings.Builder
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if s.Desc != "" {
+			fmt.Fprintf(&b, "# %s\n", s.Desc)
+		}
+		fmt.Fprintf(&b, "Host %s\n", quoteSSHConfig(s.Name))
+		fmt.Fprintf(&b, "    HostName %s\n", s.Host)
+		fmt.Fprintf(&b, "    User %s\n", quoteSSHConfig(s.User))
+		fmt.Fprintf(&b, "    Port %d\n", s.Port)
+		for _, key := range s.keys() {
+			fmt.Fprintf(&b, "    IdentityFile %s\n", quoteSSHConfig(key))
+		}
+		if len(s.Jump) > 0 {
+			fmt.Fprintf(&b, "    ProxyJump %s\n", strings.Join(s.Jump, ","))
+		}
+		if *s.ForwardAgent {
+			b.WriteString("    ForwardAgent yes\n")
+		}
+		for _, lf := range s.LocalForwards {
+			fmt.Fprintf(&b, "    LocalForward %s %s\n", net.JoinHostPort(lf.Bind, strconv.Itoa(lf.LocalPort)),
+				net.JoinHostPort(lf.RemoteHost, strconv.Itoa(lf.RemotePort)))
+		}
+		for _, rf := range s.RemoteForwards {
+			fmt.Fprintf(&b, "    RemoteForward %s %s\n", net.JoinHostPort(rf.Bind, strconv.Itoa(rf.RemotePort)),
+				net.JoinHostPort(rf.LocalHost, strconv.Itoa(rf.LocalPort)))
+		}
+		if s.SocksPort > 0 {
+			fmt.Fprintf(&b, "    DynamicForward %d\n", s.SocksPort)
+		}
+		if s.ServerAliveInterval > 0 {
+			fmt.Fprintf(&b, "    ServerAliveInterval %d\n", s.ServerAliveInterval)
+			fmt.Fprintf(&b, "    ServerAliveCountMax %d\n", s.ServerAliveCountMax)
+		}
+		if s.ConnectTimeout > 0 {
+			fmt.Fprintf(&b, "    ConnectTimeout %d\n", s.ConnectTimeout)
+		}
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quoteSSHConfig(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var invalidAnsibleGroup = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// The exportAnsible function writes an INI inventory, the servers are grouped
+// by their groups and the jump hosts are resolved to their addresses.
+func exportAnsible(w io.Writer, list []*Server) error {
+	var groups []string
+	members := make(map[string][]*Server)
+	for i, j := 0, len(list); i < j; i++ {
+		group := invalidAnsibleGroup.ReplaceAllString(list[i].Group, "_")
+		if _, found := members[group]; !found {
+			groups = append(groups, group)
+		}
+		members[group] = append(members[group], list[i])
+	}
+
+	var b strings.Builder
+	for i, j := 0, len(groups); i < j; i++ {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", groups[i])
+		for _, s := range members[groups[i]] {
+			fmt.Fprintf(&b, "%s ansible_host=%s ansible_port=%d ansible_user=%s", strings.ReplaceAll(s.Name, " ", "_"),
+				s.Host, s.Port, s.User)
+			if keys := s.keys(); len(keys) > 0 {
+				fmt.Fprintf(&b, " ansible_ssh_private_key_file=%s", Cfg.path(keys[0]))
+			}
+			hops, err := Cfg.jumps(s, nil)
+			if err != nil {
+				return err
+			}
+			if len(hops) > 0 {
+				jumps := make([]string, 0, len(hops))
+				for k, l := 0, len(hops); k < l; k++ {
+					jumps = append(jumps, hops[k].User+"@"+hops[k].Addr)
+				}
+				fmt.Fprintf(&b, " ansible_ssh_common_args='-J %s'", strings.Join(jumps, ","))
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type LocalForward struct {
+	Bind       string `yaml:"bind"`       // 本地监听地址（默认 127.0.0.1）
+	LocalPort  int    `yaml:"localPort"`  // 本地监听端口
+	RemoteHost string `yaml:"remoteHost"` // 远程服务器可以访问的目标主机（默认 127.0.0.1）
+	RemotePort int    `yaml:"remotePort"` // 目标端口
+}
+
+func (f *LocalForward) init() error {
+	if f.Bind == "" {
+		f.Bind = "127.0.0.1"
+	}
+	if f.RemoteHost == "" {
+		f.RemoteHost = "127.0.0.1"
+	}
+	if f.LocalPort <= 0 || f.LocalPort > 65535 {
+		return fmt.Errorf("invalid local forward port: %d", f.LocalPort)
+	}
+	if f.RemotePort <= 0 || f.RemotePort > 65535 {
+		return fmt.Errorf("invalid local forward remote port: %d", f.RemotePort)
+	}
+	return nil
+}
+
+func (f *LocalForward) String() string {
+	return net.JoinHostPort(f.Bind, strconv.Itoa(f.LocalPort)) + " -> " +
+		net.JoinHostPort(f.RemoteHost, strconv.Itoa(f.RemotePort))
+}
+
+type RemoteForward struct {
+	Bind       string `yaml:"bind"`       // 远程服务器监听地址（默认 127.0.0.1）
+	RemotePort int    `yaml:"remotePort"` // 远程服务器监听端口（为 0 时由服务器分配）
+	LocalHost  string `yaml:"localHost"`  // 本地可以访问的目标主机（默认 127.0.0.1）
+	LocalPort  int    `yaml:"localPort"`  // 目标端口
+}
+
+func (f *RemoteForward) init() error {
+	if f.Bind == "" {
+		f.Bind = "127.0.0.1"
+	}
+	if f.LocalHost == "" {
+		f.LocalHost = "127.0.0.1"
+	}
+	if f.RemotePort < 0 || f.RemotePort > 65535 {
+		return fmt.Errorf("invalid remote forward port: %d", f.RemotePort)
+	}
+	if f.LocalPort <= 0 || f.LocalPort > 65535 {
+		return fmt.Errorf("invalid remote forward local port: %d", f.LocalPort)
+	}
+	return nil
+}
+
+func (f *RemoteForward) String() string {
+	return net.JoinHostPort(f.Bind, strconv.Itoa(f.RemotePort)) + " -> " +
+		net.JoinHostPort(f.LocalHost, strconv.Itoa(f.LocalPort))
+}
+
+// Forwarder manages the port forwards of one connected remote server.
+type Forwarder struct {
+	client   *ssh.Client
+	mu       sync.Mutex
+	forwards []*forward
+	closed   bool
+}
+
+type forward struct {
+	kind  string // L, R or D
+	bind  string
+	port  int
+	name  string
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func NewForwarder(client *ssh.Client) *Forwarder {
+	return &Forwarder{client: client}
+}
+
+func (f *Forwarder) Local(lf *LocalForward) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(lf.Bind, strconv.Itoa(lf.LocalPort)))
+	if err != nil {
+		return err
+	}
+	target := net.JoinHostPort(lf.RemoteHost, strconv.Itoa(lf.RemotePort))
+	fw := f.add("L", lf.Bind, lf.LocalPort, "L "+lf.String(), ln)
+	go fw.serve(func(net.Conn) (net.Conn, error) { return f.client.Dial("tcp", target) })
+	return nil
+}
+
+func (f *Forwarder) Remote(rf *RemoteForward) error {
+	ln, err := f.client.Listen("tcp", net.JoinHostPort(rf.Bind, strconv.Itoa(rf.RemotePort)))
+	if err != nil {
+		return err
+	}
+	name, port := rf.String(), rf.RemotePort
+	if rf.RemotePort == 0 {
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+			name = net.JoinHostPort(rf.Bind, strconv.Itoa(addr.Port)) + " -> " +
+				net.JoinHostPort(rf.LocalHost, strconv.Itoa(rf.LocalPort))
+		}
+	}
+	target := net.JoinHostPort(rf.LocalHost, strconv.Itoa(rf.LocalPort))
+	fw := f.add("R", rf.Bind, port, "R "+name, ln)
+	go fw.serve(func(net.Conn) (net.Conn, error) { return net.DialTimeout("tcp", target, time.Second*3) })
+	return nil
+}
+
+func (f *Forwarder) Dynamic(bind string, port int) (net.Addr, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	fw := f.add("D", bind, ln.Addr().(*net.TCPAddr).Port, "D "+ln.Addr().String()+" (SOCKS5)", ln)
+	go fw.serve(func(conn net.Conn) (net.Conn, error) { return socks(conn, f.client.Dial) })
+	return ln.Addr(), nil
+}
+
+func (f *Forwarder) add(kind, bind string, port int, name string, ln net.Listener) *forward {
+	fw := &forward{kind: kind, bind: bind, port: port, name: name, ln: ln, conns: make(map[net.Conn]struct{})}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		fw.close()
+	} else {
+		f.forwards = append(f.forwards, fw)
+	}
+	return fw
+}
+
+func (f *Forwarder) List() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	list := make([]string, 0, len(f.forwards))
+	for i, j := 0, len(f.forwards); i < j; i++ {
+		list = append(list, f.forwards[i].name)
+	}
+	return list
+}
+
+// Cancel closes the forwards of the kind (L, R or D) listening on the port,
+// an empty bind address matches any bind address.
+func (f *Forwarder) Cancel(kind, bind string, port int) error {
+	f.mu.Lock()
+	var cancelled []*forward
+	forwards := f.forwards[:0]
+	for i, j := 0, len(f.forwards); i < j; i++ {
+		fw := f.forwards[i]
+		if fw.kind == kind && fw.port == port && (bind == "" || fw.bind == bind) {
+			cancelled = append(cancelled, fw)
+		} else {
+			forwards = append(forwards, fw)
+		}
+	}
+	f.forwards = forwards
+	f.mu.Unlock()
+
+	if len(cancelled) == 0 {
+		return fmt.Errorf("no %s forward on port %d", kind, port)
+	}
+	for i, j := 0, len(cancelled); i < j; i++ {
+		cancelled[i].close()
+	}
+	return nil
+}
+
+func (f *Forwarder) Close() {
+	f.mu.Lock()
+	forwards := f.forwards
+	f.forwards, f.closed = nil, true
+	f.mu.Unlock()
+
+	for i, j := 0, len(forwards); i < j; i++ {
+		forwards[i].close()
+	}
+}
+
+func (fw *forward) serve(dial func(net.Conn) (net.Conn, error)) {
+	for {
+		conn, err := fw.ln.Accept()
+		if err != nil {
+			return
+		}
+		go fw.handle(conn, dial)
+	}
+}
+
+func (fw *forward) handle(conn net.Conn, dial func(net.Conn) (net.Conn, error)) {
+	if !fw.track(conn) {
+		doClose(conn)
+		return
+	}
+	defer fw.untrack(conn)
+
+	remote, err := dial(conn)
+	if err != nil {
+		Error("Forward %s error: %s", fw.name, err)
+		return
+	}
+	if !fw.track(remote) {
+		doClose(remote)
+		return
+	}
+	defer fw.untrack(remote)
+
+	pipe(conn, remote)
+}
+
+func pipe(a, b io.ReadWriter) {
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(a, b); closeWrite(a); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(b, a); closeWrite(b); done <- struct{}{} }()
+	<-done
+	<-done
+}
+
+func closeWrite(w io.Writer) {
+	if c, ok := w.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+func (fw *forward) track(conn net.Conn) bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.conns == nil {
+		return false
+	}
+	fw.conns[conn] = struct{}{}
+	return true
+}
+
+func (fw *forward) untrack(conn net.Conn) {
+	fw.mu.Lock()
+	if fw.conns != nil {
+		delete(fw.conns, conn)
+	}
+	fw.mu.Unlock()
+	doClose(conn)
+}
+
+func (fw *forward) close() {
+	doClose(fw.ln)
+
+	fw.mu.Lock()
+	conns := fw.conns
+	fw.conns = nil
+	fw.mu.Unlock()
+
+	for conn := range conns {
+		doClose(conn)
+	}
+}
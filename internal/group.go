@@ -0,0 +1,208 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-runewidth"
+)
+
+// The colors used to tell the output of the servers apart.
+var hostColors = []color.Attribute{
+	color.FgHiCyan, color.FgHiGreen, color.FgHiMagenta, color.FgHiBlue, color.FgHiYellow,
+	color.FgCyan, color.FgGreen, color.FgMagenta, color.FgBlue, color.FgYellow,
+}
+
+type groupResult struct {
+	server   *Server
+	code     int
+	err      error
+	duration time.Duration
+}
+
+func (c *Config) GroupList(group string) []*Server {
+	var list []*Server
+	for i, j := 0, len(c.Servers); i < j; i++ {
+		if c.Servers[i].Group == group {
+			list = append(list, c.Servers[i])
+		}
+	}
+	return list
+}
+
+// RunGroup runs the command on all servers of the group, at most concurrency
+// servers at the same time, and prints a summary of the results at the end.
+func RunGroup(group, command string, concurrency int, dir string) int {
+	list := Cfg.GroupList(group)
+	if len(list) == 0 {
+		Error("There are no remote servers in group %s.", group)
+		return 1
+	}
+	if dir != "" {
+		if err := os.MkdirAll(Cfg.path(dir), 0755); err != nil {
+			Error("Create output directory error: %s", err)
+			return 1
+		}
+	}
+
+	var n int
+	for i, j := 0, len(list); i < j; i++ {
+		if nn := runewidth.StringWidth(list[i].Name); nn > n {
+			n = nn
+		}
+	}
+
+	mu := new(sync.Mutex)
+	results := make([]*groupResult, len(list))
+	sem := make(chan struct{}, concurrency)
+	wg := new(sync.WaitGroup)
+	for i, j := 0, len(list); i < j; i++ {
+		prefix := color.New(hostColors[i%len(hostColors)]).Sprintf("%s | ", runewidth.FillRight(list[i].Name, n))
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			stdout := &prefixWriter{mu: mu, w: os.Stdout, prefix: prefix}
+			stderr := &prefixWriter{mu: mu, w: os.Stderr, prefix: prefix}
+			results[i] = runOnServer(list[i], command, stdout, stderr, dir)
+		}(i)
+	}
+	wg.Wait()
+
+	return showGroupResults(results)
+}
+
+func runOnServer(s *Server, command string, stdout, stderr *prefixWriter, dir string) *groupResult {
+	r := &groupResult{server: s, code: ExitStatusUnknown}
+	start := time.Now()
+	defer func() {
+		stdout.Flush()
+		stderr.Flush()
+		r.duration = time.Since(start)
+	}()
+
+	var out, errOut io.Writer = stdout, stderr
+	if dir != "" {
+		f, err := os.OpenFile(filepath.Join(Cfg.path(dir), s.Name+".log"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		defer doClose(f)
+		out, errOut = io.MultiWriter(stdout, f), io.MultiWriter(stderr, f)
+	}
+
+	client, err := Dial(s)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer doClose(client)
+
+	r.code, r.err = Execute(client.Client, s, command, false, nil, out, errOut)
+	return r
+}
+
+func showGroupResults(results []*groupResult) int {
+	counts := []int{4, 4, 6, 4} // name host status time
+	rows := make([][]string, 0, len(results))
+	var failed int
+	for i, j := 0, len(results); i < j; i++ {
+		r := results[i]
+		status := "OK"
+		if r.err != nil || r.code != 0 {
+			status = "FAILED"
+			failed++
+		}
+		row := []string{r.server.Name, r.server.Host, status, strconv.Itoa(r.code), r.duration.Round(time.Millisecond).String(), ""}
+		if r.err != nil {
+			row[5] = r.err.Error()
+		}
+		// The exit status has a fixed width, so the time is the fourth counted column.
+		for k, column := range []int{0, 1, 2, 4} {
+			if n := runewidth.StringWidth(row[column]); n > counts[k] {
+				counts[k] = n
+			}
+		}
+		rows = append(rows, row)
+	}
+	format := fmt.Sprintf(" %%-%ds  %%-%ds  %%-%ds  %%4s  %%-%ds  %%s", counts[0], counts[1], counts[2], counts[3])
+
+	Echo("")
+	Echo(color.New(color.FgYellow).Sprintf(format, "NAME", "HOST", "STATUS", "EXIT", "TIME", "ERROR"))
+	for i, j := 0, len(rows); i < j; i++ {
+		args := make([]interface{}, len(rows[i]))
+		for k, l := 0, len(rows[i]); k < l; k++ {
+			args[k] = rows[i][k]
+		}
+		if rows[i][2] == "OK" {
+			Echo(color.New(color.FgCyan).Sprintf(format, args...))
+		} else {
+			Echo(color.New(color.FgRed).Sprintf(format, args...))
+		}
+	}
+	Echo(color.YellowString(" Total: %d  Succeeded: %d  Failed: %d", len(results), len(results)-failed, failed))
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// The prefixWriter writes the prefix before every line, the lines written by
+// all writers sharing the same lock are never mixed up.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		n := bytes.IndexByte(p.buf, '\n')
+		if n < 0 {
+			break
+		}
+		p.line(p.buf[:n])
+		p.buf = p.buf[n+1:]
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.line(p.buf)
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) line(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, _ = fmt.Fprintf(p.w, "%s%s\n", p.prefix, strings.TrimRight(string(b), "\r"))
+}
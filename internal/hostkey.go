@@ -0,0 +1,208 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The known_hosts files are shared by all connections, and the trust prompt
+// must not be interleaved with another one.
+var knownHostsMu sync.Mutex
+
+func (c *Config) knownHostsFiles() []string {
+	files := []string{c.KnownHosts}
+	if c.exist("/etc/ssh/ssh_known_hosts") {
+		files = append(files, "/etc/ssh/ssh_known_hosts")
+	}
+	return files
+}
+
+func (c *Config) knownHostsCallback() (ssh.HostKeyCallback, error) {
+	files := c.knownHostsFiles()
+	exists := make([]string, 0, len(files))
+	for i, j := 0, len(files); i < j; i++ {
+		if c.exist(files[i]) {
+			exists = append(exists, files[i])
+		}
+	}
+	return knownhosts.New(exists...)
+}
+
+func (c *Config) hostKeyCallback(s *Server) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if s.HostKey != "" {
+			return c.checkPinnedHostKey(s, key)
+		}
+
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		callback, err := c.knownHostsCallback()
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return c.trustHostKey(hostname, remote, key)
+			}
+			return c.changedHostKey(hostname, key, keyErr.Want)
+		}
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			return fmt.Errorf("the host key of %s has been revoked (%s:%d)",
+				hostname, revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+		}
+		return err
+	}
+}
+
+// The host key algorithms are limited to the known key types of the host, otherwise
+// the server may offer a key of another type and it will be treated as a changed key.
+func (c *Config) hostKeyAlgorithms(s *Server) []string {
+	if s.HostKey != "" {
+		return nil
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	callback, err := c.knownHostsCallback()
+	if err != nil {
+		return nil
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil
+	}
+	remote := &net.TCPAddr{IP: net.IPv4zero}
+	var keyErr *knownhosts.KeyError
+	if err = callback(s.Addr, remote, signer.PublicKey()); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+	algorithms := make([]string, 0, len(keyErr.Want))
+	for i, j := 0, len(keyErr.Want); i < j; i++ {
+		algorithms = append(algorithms, keyErr.Want[i].Key.Type())
+	}
+	return algorithms
+}
+
+func (c *Config) checkPinnedHostKey(s *Server, key ssh.PublicKey) error {
+	pin := strings.TrimSpace(s.HostKey)
+	if strings.HasPrefix(pin, "MD5:") {
+		if strings.TrimPrefix(pin, "MD5:") == ssh.FingerprintLegacyMD5(key) {
+			return nil
+		}
+	} else if strings.TrimPrefix(pin, "SHA256:") == strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:") {
+		return nil
+	}
+	Error("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	Error("@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @")
+	Error("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	Error("The %s host key of server %s does not match the pinned fingerprint.", key.Type(), s.Name)
+	Error("Pinned fingerprint: %s", pin)
+	Error("Remote fingerprint: %s", ssh.FingerprintSHA256(key))
+	return errors.New("the host key does not match the pinned fingerprint")
+}
+
+func (c *Config) changedHostKey(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	Error("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	Error("@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @")
+	Error("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	Error("IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!")
+	Error("The fingerprint for the %s key sent by %s is:", key.Type(), hostname)
+	Error("%s", ssh.FingerprintSHA256(key))
+	for i, j := 0, len(want); i < j; i++ {
+		Error("Known %s key in %s:%d is %s", want[i].Key.Type(), want[i].Filename, want[i].Line,
+			ssh.FingerprintSHA256(want[i].Key))
+	}
+	return fmt.Errorf("the host key of %s has changed", hostname)
+}
+
+func (c *Config) trustHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%s is not a known host and there is no terminal to confirm it", hostname)
+	}
+	host := hostname
+	if ip := remote.String(); ip != hostname {
+		if h, _, err := net.SplitHostPort(ip); err == nil {
+			host = fmt.Sprintf("%s (%s)", hostname, h)
+		}
+	}
+	Echo(color.YellowString("The authenticity of host %s can't be established.", host))
+	Echo(color.YellowString("%s key fingerprint is %s.", key.Type(), ssh.FingerprintSHA256(key)))
+	for {
+		answer, err := Ask(color.YellowString("Are you sure you want to continue connecting (yes/no)? "))
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(answer) {
+		case "yes", "y":
+			if err = c.appendKnownHost(hostname, key); err != nil {
+				return err
+			}
+			Echo(color.YellowString("Permanently added %s (%s) to the list of known hosts.", hostname, key.Type()))
+			return nil
+		case "no", "n":
+			return fmt.Errorf("the host key of %s is not trusted", hostname)
+		}
+	}
+}
+
+func (c *Config) appendKnownHost(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(c.KnownHosts), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.KnownHosts, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer doClose(f)
+
+	line := knownhosts.Normalize(hostname)
+	if c.HashKnownHosts {
+		line = knownhosts.HashHostname(line)
+	}
+	line = knownhosts.Line([]string{line}, key) + "\n"
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+	_, err = f.WriteString(line)
+	return err
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// The withoutTerminal function runs the function with a stdin which is not a terminal.
+func withoutTerminal(t *testing.T, f func()) {
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doClose(stdin)
+	defer func(old *os.File) { os.Stdin = old }(os.Stdin)
+	os.Stdin = stdin
+	f()
+}
+
+func dialHostKeyPhase(t *testing.T, s *Server) error {
+	client, err := Dial(s)
+	if err == nil {
+		doClose(client)
+		return nil
+	}
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Phase != PhaseHostKey {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return err
+}
+
+func TestPinnedHostKey(t *testing.T) {
+	srv := newSSHTestServer(t)
+	other := newTestPublicKey(t)
+	key := srv.signer.PublicKey()
+	tests := []struct {
+		name string
+		pin  string
+		ok   bool
+	}{
+		{"sha256", ssh.FingerprintSHA256(key), true},
+		{"sha256 without prefix", strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:"), true},
+		{"md5", "MD5:" + ssh.FingerprintLegacyMD5(key), true},
+		{"other sha256", ssh.FingerprintSHA256(other), false},
+		{"other md5", "MD5:" + ssh.FingerprintLegacyMD5(other), false},
+	}
+	for _, tt := range tests {
+		s := srv.server(t, "test")
+		s.HostKey = tt.pin
+		newTestConfig(t, s)
+		if err := dialHostKeyPhase(t, s); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	srv := newSSHTestServer(t)
+	s := srv.server(t, "test")
+	s.HostKey = ""
+	newTestConfig(t, s)
+	key := srv.signer.PublicKey()
+	host := knownhosts.Normalize(s.Addr)
+	if !strings.HasPrefix(host, "[") {
+		t.Fatalf("the address %s is not written with the port", host)
+	}
+
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+	}{
+		{"host and port", knownhosts.Line([]string{host}, key), true},
+		{"hashed", knownhosts.Line([]string{knownhosts.HashHostname(host)}, key), true},
+		{"changed", knownhosts.Line([]string{host}, newTestPublicKey(t)), false},
+		{"revoked", "@revoked " + knownhosts.Line([]string{"*"}, key), false},
+	}
+	for _, tt := range tests {
+		data := []byte(tt.line + "\n")
+		if err := ioutil.WriteFile(Cfg.KnownHosts, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// The changed keys are refused even if the user could be asked.
+		err := dialHostKeyPhase(t, s)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		if got, _ := ioutil.ReadFile(Cfg.KnownHosts); string(got) != string(data) {
+			t.Errorf("%s: the known_hosts file is changed: %q", tt.name, got)
+		}
+	}
+}
+
+func TestUnknownHostWithoutTerminal(t *testing.T) {
+	srv := newSSHTestServer(t)
+	s := srv.server(t, "test")
+	s.HostKey = ""
+	newTestConfig(t, s)
+
+	withoutTerminal(t, func() {
+		if err := dialHostKeyPhase(t, s); err == nil || !strings.Contains(err.Error(), "no terminal") {
+			t.Errorf("got %v", err)
+		}
+	})
+	if _, err := os.Stat(Cfg.KnownHosts); !os.IsNotExist(err) {
+		t.Errorf("the known_hosts file is written: %v", err)
+	}
+}
+
+func TestAppendKnownHost(t *testing.T) {
+	key, remote := newTestPublicKey(t), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 2222}
+	for _, hashed := range []bool{false, true} {
+		newTestConfig(t)
+		Cfg.KnownHosts = filepath.Join(t.TempDir(), "ssh", "known_hosts")
+		Cfg.HashKnownHosts = hashed
+		// The existing line without a newline is not joined with the new one.
+		if err := os.MkdirAll(filepath.Dir(Cfg.KnownHosts), 0700); err != nil {
+			t.Fatal(err)
+		}
+		other := knownhosts.Line([]string{"other"}, newTestPublicKey(t))
+		if err := ioutil.WriteFile(Cfg.KnownHosts, []byte(other), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for _, hostname := range []string{"10.0.0.5:2222", "web.example.com:22"} {
+			if err := Cfg.appendKnownHost(hostname, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, _ := ioutil.ReadFile(Cfg.KnownHosts)
+		if strings.Contains(string(data), "10.0.0.5") == hashed {
+			t.Errorf("hashed %t: got %q", hashed, data)
+		}
+		callback, err := knownhosts.New(Cfg.KnownHosts)
+		if err != nil {
+			t.Fatalf("hashed %t: %s", hashed, err)
+		}
+		for _, hostname := range []string{"10.0.0.5:2222", "web.example.com:22"} {
+			if err = callback(hostname, remote, key); err != nil {
+				t.Errorf("hashed %t: %s: %s", hashed, hostname, err)
+			}
+		}
+		if err = callback("10.0.0.5:22", remote, key); err == nil {
+			t.Errorf("hashed %t: the key is known on another port", hashed)
+		}
+	}
+}
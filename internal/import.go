@@ -0,0 +1,202 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+func RunImport(args []string) int {
+	flags := newFlagSet("import", "ssh-config [-f file] [-g group] [-w]")
+	file := flags.String("f", "", "The ssh config file (default sshConfigFile of the config, or ~/.ssh/config).")
+	group := flags.String("g", "", "The group of the imported servers (default sshConfigGroup of the config, or ssh-config).")
+	write := flags.Bool("w", false, "Add the servers to the config file instead of printing them.")
+	if len(args) == 0 || args[0] != "ssh-config" {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	if *file == "" {
+		if *file = Cfg.SSHConfigFile; *file == "" {
+			*file = filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+		}
+	}
+	if *group == "" {
+		if *group = Cfg.SSHConfigGroup; *group == "" {
+			*group = "ssh-config"
+		}
+	}
+
+	servers, err := LoadSSHConfig(Cfg.path(*file), *group)
+	if err != nil {
+		Error("Import ssh config error: %s", err)
+		return 1
+	}
+	if !*write {
+		list := make([]yaml.MapSlice, 0, len(servers))
+		for i, j := 0, len(servers); i < j; i++ {
+			list = append(list, importedServer(servers[i]))
+		}
+		data, err := yaml.Marshal(yaml.MapSlice{{Key: "servers", Value: list}})
+		if err != nil {
+			Error("Import ssh config error: %s", err)
+			return 1
+		}
+		fmt.Print(string(data))
+		return 0
+	}
+
+	target := Cfg.File
+	if target == "" {
+		target = filepath.Join(os.Getenv("HOME"), ".j2.yaml")
+	}
+	n, err := importServers(target, servers)
+	if err != nil {
+		Error("Write config file %s error: %s", target, err)
+		return 1
+	}
+	Echo(color.GreenString("Added %d of %d server(s) to %s.", n, len(servers), target))
+	return 0
+}
+
+// The importedServer function returns the options of the imported server,
+// the empty ones are left out so the defaults of J2 are used.
+func importedServer(s *Server) yaml.MapSlice {
+	m := yaml.MapSlice{{Key: "name", Value: s.Name}}
+	if s.User != "" {
+		m = append(m, yaml.MapItem{Key: "user", Value: s.User})
+	}
+	m = append(m, yaml.MapItem{Key: "host", Value: s.Host})
+	if s.Port != 0 {
+		m = append(m, yaml.MapItem{Key: "port", Value: s.Port})
+	}
+	if len(s.PrivateKeys) > 0 {
+		m = append(m, yaml.MapItem{Key: "privateKeys", Value: s.PrivateKeys})
+	}
+	if len(s.Jump) > 0 {
+		m = append(m, yaml.MapItem{Key: "jump", Value: []string(s.Jump)})
+	}
+	return append(m, yaml.MapItem{Key: "group", Value: s.Group})
+}
+
+// The importServers function adds the servers which are not in the config file
+// yet, they are inserted as text so the rest of the file is left untouched.
+func importServers(file string, servers []*Server) (int, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	var doc struct {
+		Servers []struct {
+			Name string `yaml:"name"`
+		} `yaml:"servers"`
+	}
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return 0, err
+	}
+	names := make(map[string]bool)
+	for i, j := 0, len(doc.Servers); i < j; i++ {
+		names[doc.Servers[i].Name] = true
+	}
+
+	var list []yaml.MapSlice
+	for i, j := 0, len(servers); i < j; i++ {
+		if !names[servers[i].Name] {
+			list = append(list, importedServer(servers[i]))
+		}
+	}
+	if len(list) == 0 {
+		return 0, nil
+	}
+	entries, err := yaml.Marshal(list)
+	if err != nil {
+		return 0, err
+	}
+	out, err := insertServers(string(data), string(entries))
+	if err != nil {
+		return 0, err
+	}
+	return len(list), ioutil.WriteFile(file, []byte(out), 0600)
+}
+
+// The insertServers function inserts the entries after the last server of the
+// servers list, with the indentation of the list.
+func insertServers(text, entries string) (string, error) {
+	lines := strings.SplitAfter(text, "\n")
+	start := -1
+	for i, j := 0, len(lines); i < j; i++ {
+		if strings.HasPrefix(lines[i], "servers:") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		return text + "servers:\n" + indentLines(entries, "  "), nil
+	}
+	// The empty list is turned into a block list.
+	if value := strings.TrimSpace(lines[start][len("servers:"):]); strings.HasPrefix(value, "[]") {
+		lines[start] = "servers:" + strings.Replace(lines[start][len("servers:"):], "[]", "", 1)
+	}
+	if value := strings.TrimSpace(lines[start][len("servers:"):]); value != "" && !strings.HasPrefix(value, "#") {
+		return "", fmt.Errorf("the servers are not written as a block list, add them manually")
+	}
+
+	end, prefix, found := start+1, "  ", false
+	for i, j := start+1, len(lines); i < j; i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// The list ends at the next top level key.
+		if line[0] != ' ' && line[0] != '\t' && line[0] != '-' {
+			break
+		}
+		if trimmed := strings.TrimLeft(line, " "); !found && strings.HasPrefix(trimmed, "-") {
+			prefix, found = line[:len(line)-len(trimmed)], true
+		}
+		end = i + 1
+	}
+	block := indentLines(entries, prefix)
+	if !strings.HasSuffix(lines[end-1], "\n") {
+		block = "\n" + block
+	}
+	return strings.Join(lines[:end], "") + block + strings.Join(lines[end:], ""), nil
+}
+
+func indentLines(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, j := 0, len(lines); i < j; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestInsertServers(t *testing.T) {
+	entries := "- name: web\n  host: 10.0.0.5\n"
+	tests := []struct {
+		name string
+		text string
+		want string
+		err  bool
+	}{
+		{"empty file", "", "servers:\n  - name: web\n    host: 10.0.0.5\n", false},
+		{"no servers", "pageSize: 6 # comment\n", "pageSize: 6 # comment\nservers:\n  - name: web\n    host: 10.0.0.5\n", false},
+		{"no newline", "pageSize: 6", "pageSize: 6\nservers:\n  - name: web\n    host: 10.0.0.5\n", false},
+		{
+			"indented list",
+			"# J2\nservers:\n  # first\n  - name: db\n    host: db # the host\n\n# groups\ngroups: {}\n",
+			"# J2\nservers:\n  # first\n  - name: db\n    host: db # the host\n  - name: web\n    host: 10.0.0.5\n\n# groups\ngroups: {}\n",
+			false,
+		},
+		{
+			"unindented list",
+			"servers:\n- name: db\n  host: db",
+			"servers:\n- name: db\n  host: db\n- name: web\n  host: 10.0.0.5\n",
+			false,
+		},
+		{"empty list", "servers: # none\npageSize: 6\n", "servers: # none\n  - name: web\n    host: 10.0.0.5\npageSize: 6\n", false},
+		{"empty flow list", "servers: [] # none\n", "servers:  # none\n  - name: web\n    host: 10.0.0.5\n", false},
+		{"flow list", "servers: [{name: db, host: db}]\n", "", true},
+	}
+	for _, tt := range tests {
+		got, err := insertServers(tt.text, entries)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
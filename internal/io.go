@@ -17,19 +17,27 @@ package internal
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
 )
 
 var State *terminal.State
 var mu sync.Mutex
+var askMu sync.Mutex
 
 func init() {
 	mu.Lock()
 	defer mu.Unlock()
 	in := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(in) {
+		return
+	}
 	state, err := terminal.MakeRaw(in)
 	if err != nil {
 		panic(err)
@@ -54,14 +62,67 @@ func EchoAndExit(format string, args ...interface{}) {
 func Error(format string, args ...interface{}) {
 	prefix := color.New(color.FgHiRed).Sprint("ERROR")
 	if len(args) == 0 {
-		fmt.Printf("%s %s\r\n", prefix, color.RedString(format))
+		fmt.Fprintf(os.Stderr, "%s %s\r\n", prefix, color.RedString(format))
 	} else {
-		fmt.Printf("%s %s\r\n", prefix, color.RedString(format, args...))
+		fmt.Fprintf(os.Stderr, "%s %s\r\n", prefix, color.RedString(format, args...))
+	}
+}
+
+func Warn(format string, args ...interface{}) {
+	prefix := color.New(color.FgHiYellow).Sprint("WARN")
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s\r\n", prefix, color.YellowString(format))
+	} else {
+		fmt.Fprintf(os.Stderr, "%s %s\r\n", prefix, color.YellowString(format, args...))
+	}
+}
+
+func Ask(format string, args ...interface{}) (string, error) {
+	askMu.Lock()
+	defer askMu.Unlock()
+
+	fmt.Printf(format, args...)
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := syscall.Read(int(os.Stdin.Fd()), buf)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 || buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+func AskPassword(format string, args ...interface{}) (string, error) {
+	askMu.Lock()
+	defer askMu.Unlock()
+
+	fmt.Printf(format, args...)
+	b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Print("\r\n")
+	return string(b), err
+}
+
+// The waitInput function reports whether the file descriptor can be read
+// within the timeout, so the readers can notice that they should stop.
+func waitInput(fd int, timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if err != nil {
+		if err == unix.EINTR {
+			return false, nil
+		}
+		return false, err
 	}
+	return n > 0, nil
 }
 
 func ErrorAndExit(format string, args ...interface{}) {
-	Error(format, args)
+	Error(format, args...)
 	Exit(1)
 }
 
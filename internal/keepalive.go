@@ -0,0 +1,57 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The keepalive function sends keepalive@openssh.com requests to the server,
+// the connection is closed when the server does not answer max requests in a row.
+// Any reply, even a failure, means that the server is alive.
+func keepalive(client *ssh.Client, interval time.Duration, max int, done <-chan struct{}) <-chan error {
+	errs := make(chan error, 1)
+	if interval <= 0 {
+		return errs
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var missed int32
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if atomic.AddInt32(&missed, 1) > int32(max) {
+				errs <- fmt.Errorf("the server did not answer %d keepalive requests, the connection is dead", max)
+				doClose(client)
+				return
+			}
+			go func() {
+				if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+					atomic.StoreInt32(&missed, 0)
+				}
+			}()
+		}
+	}()
+	return errs
+}
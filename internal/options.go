@@ -97,11 +97,15 @@ type ConsoleParserWrapper struct {
 }
 
 func NewConsoleParserWrapper() prompt.ConsoleParser {
-	return &ConsoleParserWrapper{ConsoleParser: prompt.NewStandardInputParser()}
+	return &ConsoleParserWrapper{}
 }
 
 func (w *ConsoleParserWrapper) Setup() error {
 	if atomic.CompareAndSwapInt64(&w.status, 0, 1) {
+		// The terminal is opened on demand, so the commands can run without a terminal.
+		if w.ConsoleParser == nil {
+			w.ConsoleParser = prompt.NewStandardInputParser()
+		}
 		return w.ConsoleParser.Setup()
 	}
 	return nil
@@ -0,0 +1,148 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The connections shared by the servers connected in the same way.
+var pool = make(map[string]*sharedConn)
+var poolMu sync.Mutex
+
+type sharedConn struct {
+	key    string
+	client *Client
+	ttl    time.Duration
+	refs   int
+	timer  *time.Timer
+	closed bool
+}
+
+// The poolKey function returns the key of the connection of the server, the
+// servers only share the connection if the jump hosts, the host key and the
+// credentials are all the same, because the same address behind different jump
+// hosts may be different machines.
+func poolKey(s *Server) (string, bool) {
+	hops, err := Cfg.jumps(s, nil)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	list := append(hops, s)
+	for i, j := 0, len(list); i < j; i++ {
+		hop := list[i]
+		agent := hop.Agent != nil && *hop.Agent
+		_, _ = fmt.Fprintf(h, "%s@%s\x00%s\x00%s\x00%s\x00%t\x00%s\x00%s\n", hop.User, hop.Addr, hop.HostKey,
+			strings.Join(hop.AuthMethods, ","), strings.Join(hop.keys(), ","), agent, hop.IdentityAgent, hop.Password)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// The acquire function returns the pooled connection of the key, the
+// connection is checked first because it may have been idle for a while.
+func acquire(key string) *Client {
+	poolMu.Lock()
+	c := pool[key]
+	if c == nil {
+		poolMu.Unlock()
+		return nil
+	}
+	c.refs++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	poolMu.Unlock()
+
+	if err := ping(c.client.Client); err != nil {
+		c.close()
+		return nil
+	}
+	return &Client{Client: c.client.Client, shared: c}
+}
+
+// The share function adds the new connection to the pool, the connection is
+// not shared if another one has been added in the meantime.
+func share(s *Server, key string, client *Client) *Client {
+	c := &sharedConn{
+		key:    key,
+		client: client,
+		ttl:    time.Duration(s.ControlPersist) * time.Second,
+		refs:   1,
+	}
+	poolMu.Lock()
+	if pool[c.key] != nil {
+		poolMu.Unlock()
+		return client
+	}
+	pool[c.key] = c
+	poolMu.Unlock()
+
+	go func() {
+		_ = client.Wait()
+		c.close()
+	}()
+	return &Client{Client: client.Client, shared: c}
+}
+
+func (c *sharedConn) release() {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if c.refs--; c.refs > 0 || c.closed {
+		return
+	}
+	c.timer = time.AfterFunc(c.ttl, func() {
+		poolMu.Lock()
+		idle := c.refs == 0 && c.remove()
+		poolMu.Unlock()
+		if idle {
+			doClose(c.client)
+		}
+	})
+}
+
+// The close function removes the connection from the pool and closes it,
+// the connection is closed by the server or it does not answer anymore.
+func (c *sharedConn) close() {
+	poolMu.Lock()
+	ok := c.remove()
+	poolMu.Unlock()
+	if ok {
+		doClose(c.client)
+	}
+}
+
+// The remove function must be called with the pool locked, it returns false
+// if the connection has already been removed.
+func (c *sharedConn) remove() bool {
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	if pool[c.key] == c {
+		delete(pool, c.key)
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestPoolKey(t *testing.T) {
+	prod := &Server{Name: "prod", Host: "bastion.prod", Password: "secret"}
+	staging := &Server{Name: "staging", Host: "bastion.staging", Password: "secret"}
+	a := &Server{Name: "a", Host: "10.0.0.5", User: "root", Password: "secret", Jump: StringList{"prod"}}
+	b := &Server{Name: "b", Host: "10.0.0.5", User: "root", Password: "secret", Jump: StringList{"staging"}}
+	c := &Server{Name: "c", Host: "10.0.0.5", User: "root", Password: "secret", Jump: StringList{"prod"}}
+	d := &Server{Name: "d", Host: "10.0.0.5", User: "root", Password: "other", Jump: StringList{"prod"}}
+	e := &Server{Name: "e", Host: "10.0.0.5", User: "root", Password: "secret", Jump: StringList{"prod"}, HostKey: "SHA256:x"}
+	newTestConfig(t, prod, staging, a, b, c, d, e)
+
+	keys := make(map[string]string)
+	for _, s := range []*Server{a, b, c, d, e} {
+		key, ok := poolKey(s)
+		if !ok {
+			t.Fatalf("no pool key of server %s", s.Name)
+		}
+		keys[s.Name] = key
+	}
+	if keys["a"] != keys["c"] {
+		t.Error("the servers connected in the same way do not share the connection")
+	}
+	for _, name := range []string{"b", "d", "e"} {
+		if keys[name] == keys["a"] {
+			t.Errorf("server %s shares the connection of server a", name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ConnectionLostError means that the connection to the remote server was
+// broken, instead of the remote shell exiting normally.
+type ConnectionLostError struct {
+	Err error
+}
+
+func (e *ConnectionLostError) Error() string {
+	return "connection lost: " + e.Err.Error()
+}
+
+func (e *ConnectionLostError) Unwrap() error {
+	return e.Err
+}
+
+func ping(client *ssh.Client) error {
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(time.Second * 5):
+		return errors.New("the server did not answer the keepalive request")
+	}
+}
+
+const maxReconnectDelay = time.Minute
+
+func Reconnect(s *Server, lost *ConnectionLostError) error {
+	Error("Connection to server %s lost: %s", s.Name, lost.Err)
+	delay := time.Second
+	for attempt := 1; attempt <= s.ReconnectRetries; attempt++ {
+		if !countdown(s, attempt, delay) {
+			return fmt.Errorf("reconnect aborted, %s", lost)
+		}
+		err := Connect(s)
+		if err == nil {
+			return nil
+		}
+		if errors.As(err, &lost) {
+			// The session was established again, so the backoff starts over.
+			Error("Connection to server %s lost: %s", s.Name, lost.Err)
+			attempt, delay = 0, time.Second
+			continue
+		}
+		Error("Reconnect to server %s failed: %s", s.Name, err)
+		lost = &ConnectionLostError{Err: err}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+	return fmt.Errorf("gave up reconnecting after %d attempts, %s", s.ReconnectRetries, lost)
+}
+
+// The countdown function shows the time before the next reconnect attempt,
+// it returns false if the user presses any key to abort.
+func countdown(s *Server, attempt int, delay time.Duration) bool {
+	in := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(in)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = terminal.Restore(in, state) }()
+	defer fmt.Print("\r\033[K")
+
+	for left := delay; left > 0; left -= time.Second {
+		fmt.Print("\r\033[K" + color.YellowString("Reconnecting to %s in %s (attempt %d/%d), press any key to abort...",
+			s.Name, left, attempt, s.ReconnectRetries))
+		step := time.Second
+		if left < step {
+			step = left
+		}
+		if ok, _ := waitInput(in, step); ok {
+			_, _ = syscall.Read(in, make([]byte, 64))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Recorder records a session in the asciicast v2 format of asciinema.
+// See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type Recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	input   bool
+	pending map[string][]byte
+	err     error
+}
+
+type castHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+func NewRecorder(s *Server, width, height int) (*Recorder, error) {
+	dir := filepath.Join(s.RecordDir, s.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	// The sessions of the server may be started in the same second.
+	base := filepath.Join(dir, start.Format("20060102-150405"))
+	name := base + ".cast"
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	for n := 1; os.IsExist(err); n++ {
+		name = fmt.Sprintf("%s.%d.cast", base, n)
+		f, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Title:     fmt.Sprintf("%s (%s@%s)", s.Name, s.User, s.Addr),
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}
+	data, _ := json.Marshal(header)
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		doClose(f)
+		return nil, err
+	}
+	return &Recorder{f: f, start: start, input: *s.RecordInput, pending: make(map[string][]byte)}, nil
+}
+
+func (r *Recorder) Name() string {
+	return r.f.Name()
+}
+
+// Output returns the writer that records the output of the session.
+func (r *Recorder) Output() io.Writer {
+	return recorderWriter(func(b []byte) { r.event("o", b) })
+}
+
+// Input wraps the writer of the session input, so the input is also recorded
+// if the recording of the input is enabled.
+func (r *Recorder) Input(w io.WriteCloser) io.WriteCloser {
+	if !r.input {
+		return w
+	}
+	return &recorderInput{WriteCloser: w, r: r}
+}
+
+func (r *Recorder) Resize(width, height int) {
+	r.event("r", []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+// The event function writes the data as an event, the bytes of an incomplete
+// UTF-8 sequence at the end are kept until the rest of the sequence is written.
+func (r *Recorder) event(code string, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	data := append(r.pending[code], b...)
+	n := len(data) - incompleteRune(data)
+	r.pending[code] = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return
+	}
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), code, string(data[:n])})
+	if _, r.err = r.f.Write(append(line, '\n')); r.err != nil {
+		Error("Write recording %s error: %s", r.f.Name(), r.err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	return r.f.Close()
+}
+
+func incompleteRune(b []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+type recorderWriter func([]byte)
+
+func (w recorderWriter) Write(b []byte) (int, error) {
+	w(b)
+	return len(b), nil
+}
+
+type recorderInput struct {
+	io.WriteCloser
+	r *Recorder
+}
+
+func (w *recorderInput) Write(b []byte) (int, error) {
+	w.r.event("i", b)
+	return w.WriteCloser.Write(b)
+}
+
+// Play replays the asciicast v2 file, speed makes it faster or slower and the
+// idle time between two events is limited to maxIdle if it is not zero.
+func Play(name string, speed float64, maxIdle time.Duration) error {
+	f, err := os.Open(Cfg.path(name))
+	if err != nil {
+		return err
+	}
+	defer doClose(f)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("the file is empty")
+		}
+		return err
+	}
+	var header castHeader
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("invalid asciicast header: %s", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	if maxIdle == 0 && header.IdleTimeLimit > 0 {
+		maxIdle = time.Duration(header.IdleTimeLimit * float64(time.Second))
+	}
+
+	var last float64
+	for line := 2; scanner.Scan(); line++ {
+		var event []interface{}
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid asciicast event at line %d", line)
+		}
+		at, ok1 := event[0].(float64)
+		code, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("invalid asciicast event at line %d", line)
+		}
+		// The terminal can not be resized and the input has been echoed by the server.
+		if code != "o" {
+			continue
+		}
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		last = at
+		time.Sleep(delay)
+		if _, err = os.Stdout.WriteString(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
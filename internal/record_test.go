@@ -0,0 +1,34 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestNewRecorderUniqueName(t *testing.T) {
+	input := false
+	s := &Server{Name: "test", User: "root", Addr: "127.0.0.1:22", RecordDir: t.TempDir(), RecordInput: &input}
+	names := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		r, err := NewRecorder(s, 80, 24)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names[r.Name()] {
+			t.Fatalf("duplicate recording %s", r.Name())
+		}
+		names[r.Name()] = true
+		doClose(r)
+	}
+}
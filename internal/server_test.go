@@ -0,0 +1,188 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The sshTestServer is an in-process SSH server with password authentication,
+// it only supports the direct-tcpip channels used by the forwards and the jump hosts,
+// and the session channels which discard the input.
+type sshTestServer struct {
+	ln     net.Listener
+	signer ssh.Signer
+	delay  time.Duration // The delay before a direct-tcpip channel is opened.
+}
+
+func newSSHTestServer(t *testing.T) *sshTestServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	s := &sshTestServer{ln: ln, signer: signer}
+	go s.serve()
+	return s
+}
+
+// The server function returns the server config to connect to the test server.
+func (s *sshTestServer) server(t *testing.T, name string) *Server {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return &Server{
+		Name:     name,
+		User:     "test",
+		Host:     host,
+		Port:     n,
+		Password: "secret",
+		HostKey:  ssh.FingerprintSHA256(s.signer.PublicKey()),
+	}
+}
+
+func (s *sshTestServer) serve() {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "test" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(s.signer)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn, config)
+	}
+}
+
+func (s *sshTestServer) handle(conn net.Conn, config *ssh.ServerConfig) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer doClose(sc)
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() == "session" {
+			// The sessions only discard the input, there is no shell.
+			ch, creqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(creqs)
+			go func() {
+				_, _ = io.Copy(ioutil.Discard, ch)
+				_ = ch.Close()
+			}()
+			continue
+		}
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var p struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &p); err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		time.Sleep(s.delay)
+		target, err := net.Dial("tcp", net.JoinHostPort(p.Host, fmt.Sprint(p.Port)))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			_ = target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+		go func() {
+			_, _ = io.Copy(ch, target)
+			_ = ch.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(target, ch)
+			_ = target.Close()
+		}()
+	}
+}
+
+// The newTestConfig function sets up a config of the servers for the tests.
+func newTestConfig(t *testing.T, servers ...*Server) {
+	Cfg = NewConfig()
+	Cfg.ConnectTimeout = 3
+	Cfg.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	Cfg.Servers = servers
+	for i, j := 0, len(servers); i < j; i++ {
+		if err := Cfg.init(servers[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The newEchoServer function starts a TCP server which echoes the received data.
+func newEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return ln
+}
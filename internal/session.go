@@ -0,0 +1,466 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/go-prompt"
+	"github.com/fatih/color"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The output of a detached session is kept up to this size.
+const maxSessionBuffer = 1024 * 1024
+
+// Session is an interactive shell of a remote server, it keeps running in the
+// background when it is detached.
+type Session struct {
+	ID        int
+	Server    *Server
+	Started   time.Time
+	client    *Client
+	sess      *ssh.Session
+	stdin     io.WriteCloser
+	forwarder *Forwarder
+	output    *sessionOutput
+	recorder  *Recorder
+	closers   []io.Closer
+	keepalive chan struct{}
+	done      chan struct{}
+	err       error
+
+	mu     sync.Mutex
+	closed bool // the session is closed by the user
+
+	// The state of the terminal and the escape sequences, only used while attached.
+	in      int
+	state   *terminal.State
+	detach  chan struct{}
+	newline bool
+	escape  bool
+}
+
+// The open sessions, the detached sessions keep running in the background.
+var sessions []*Session
+var sessionsMu sync.Mutex
+var lastSessionID int
+
+// The sessions ended in the background, they are reported by the summary.
+var endedSessions []string
+
+// The FindSession function returns the open session of the id.
+func FindSession(id int) *Session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for i, j := 0, len(sessions); i < j; i++ {
+		if sessions[i].ID == id {
+			return sessions[i]
+		}
+	}
+	return nil
+}
+
+// The Sessions function returns the open sessions, the ended sessions are removed.
+func Sessions() []*Session {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	list := sessions[:0]
+	for i, j := 0, len(sessions); i < j; i++ {
+		select {
+		case <-sessions[i].done:
+			sessions[i].Close()
+			ended := fmt.Sprintf("#%d (%s)", sessions[i].ID, sessions[i].Server.Name)
+			if sessions[i].err != nil {
+				ended = fmt.Sprintf("#%d (%s, %s)", sessions[i].ID, sessions[i].Server.Name, sessions[i].err)
+			}
+			endedSessions = append(endedSessions, ended)
+			continue
+		default:
+		}
+		list = append(list, sessions[i])
+	}
+	sessions = list
+	return append([]*Session(nil), list...)
+}
+
+// The EndedSessions function returns the sessions ended in the background
+// since the last call.
+func EndedSessions() []string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	ended := endedSessions
+	endedSessions = nil
+	return ended
+}
+
+// The ShowSessions function prints the detached sessions.
+func ShowSessions() {
+	list := Sessions()
+	if len(list) == 0 {
+		Echo(color.YellowString("There are no detached sessions."))
+		return
+	}
+	counts := []int{4, 4} // name host
+	for i, j := 0, len(list); i < j; i++ {
+		if n := runewidth.StringWidth(list[i].Server.Name); n > counts[0] {
+			counts[0] = n
+		}
+		if n := runewidth.StringWidth(list[i].Server.Host); n > counts[1] {
+			counts[1] = n
+		}
+	}
+	format := fmt.Sprintf(" %%4s  %%-%ds  %%-%ds  %%-8s  %%s", counts[0], counts[1])
+
+	Echo("")
+	Echo(color.YellowString(format, "ID", "NAME", "HOST", "STARTED", "BUFFERED"))
+	for i, j := 0, len(list); i < j; i++ {
+		r := list[i]
+		Echo(color.CyanString(format, strconv.Itoa(r.ID), r.Server.Name, r.Server.Host,
+			r.Started.Format("15:04:05"), formatSize(int64(r.output.buffered()))))
+	}
+	Echo(color.YellowString(" Use -s <n> to resume the session and -kill <n> to close it."))
+}
+
+func findSession(id string) (*Session, error) {
+	id = strings.TrimPrefix(strings.TrimSpace(id), "#")
+	if id == "" {
+		return nil, fmt.Errorf("the session id is required, use -s to list the sessions")
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session id: %s", id)
+	}
+	Sessions() // Remove the ended sessions.
+	if r := FindSession(n); r != nil {
+		return r, nil
+	}
+	return nil, fmt.Errorf("session #%d does not exist, use -s to list the sessions", n)
+}
+
+func sessionSuggests() []prompt.Suggest {
+	list := Sessions()
+	suggests := make([]prompt.Suggest, 0, len(list))
+	for i, j := 0, len(list); i < j; i++ {
+		suggests = append(suggests, prompt.Suggest{
+			Text:        strconv.Itoa(list[i].ID),
+			Description: fmt.Sprintf("%s, started at %s.", list[i].Server.Name, list[i].Started.Format("15:04:05")),
+		})
+	}
+	return suggests
+}
+
+func addSession(s *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	lastSessionID++
+	s.ID = lastSessionID
+	sessions = append(sessions, s)
+}
+
+func removeSession(s *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for i, j := 0, len(sessions); i < j; i++ {
+		if sessions[i] == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+func OpenSession(s *Server) (*Session, error) {
+	in := int(os.Stdin.Fd())
+	width, height, err := terminal.GetSize(in)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := Dial(s)
+	if err != nil {
+		return nil, err
+	}
+	r := &Session{
+		Server:    s,
+		Started:   time.Now(),
+		client:    client,
+		forwarder: NewForwarder(client.Client),
+		output:    new(sessionOutput),
+		keepalive: make(chan struct{}),
+		done:      make(chan struct{}),
+		in:        in,
+	}
+	dead := keepalive(client.Client, time.Duration(s.ServerAliveInterval)*time.Second, s.ServerAliveCountMax, r.keepalive)
+
+	for i, j := 0, len(s.LocalForwards); i < j; i++ {
+		if err = r.forwarder.Local(s.LocalForwards[i]); err != nil {
+			Error("Local forward %s error: %s", s.LocalForwards[i], err)
+		}
+	}
+	for i, j := 0, len(s.RemoteForwards); i < j; i++ {
+		if err = r.forwarder.Remote(s.RemoteForwards[i]); err != nil {
+			Error("Remote forward %s error: %s", s.RemoteForwards[i], err)
+		}
+	}
+	if s.SocksPort > 0 {
+		if _, err = r.forwarder.Dynamic("127.0.0.1", s.SocksPort); err != nil {
+			Error("SOCKS5 proxy on port %d error: %s", s.SocksPort, err)
+		}
+	}
+
+	if r.sess, err = client.NewSession(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if *s.ForwardAgent {
+		if err = forwardAgent(client.Client, r.sess, s.IdentityAgent); err != nil {
+			Warn("Agent forwarding to server %s is not available: %s", s.Name, err)
+		}
+	}
+
+	setenv(r.sess, s)
+
+	r.stdin, _ = r.sess.StdinPipe()
+	outputs := []io.Writer{r.output}
+	start := newStartup(s, r.stdin)
+	if start != nil {
+		outputs = append(outputs, start)
+	}
+	if *s.Record {
+		if r.recorder, err = NewRecorder(s, width, height); err != nil {
+			Warn("Session of server %s is not recorded: %s", s.Name, err)
+		} else {
+			r.closers = append(r.closers, r.recorder)
+			outputs = append(outputs, r.recorder.Output())
+			r.stdin = r.recorder.Input(r.stdin)
+		}
+	}
+	if *s.LogSessions {
+		if log, err := NewSessionLog(s); err != nil {
+			Warn("Session of server %s is not logged: %s", s.Name, err)
+		} else {
+			r.closers = append(r.closers, log)
+			outputs = append(outputs, log)
+		}
+	}
+	r.sess.Stdout = io.MultiWriter(outputs...)
+	r.sess.Stderr = r.sess.Stdout
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm-256color"
+	}
+	if err = r.sess.RequestPty(term, height, width, modes); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err = r.sess.Shell(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	go r.wait(dead)
+	if start != nil {
+		go start.run()
+	}
+	addSession(r)
+	return r, nil
+}
+
+func (r *Session) wait(dead <-chan error) {
+	defer close(r.done)
+
+	err := r.sess.Wait()
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+
+	select {
+	case e := <-dead:
+		r.err = &ConnectionLostError{Err: e}
+		return
+	default:
+	}
+	if err == nil || closed {
+		return
+	}
+	switch err.(type) {
+	case *ssh.ExitMissingError:
+		// The channel is also closed without an exit status when the connection is lost.
+		if err = ping(r.client.Client); err != nil {
+			r.err = &ConnectionLostError{Err: err}
+		}
+	case *ssh.ExitError:
+	default:
+		r.err = &ConnectionLostError{Err: err}
+	}
+}
+
+// Attach connects the session to the terminal until the session ends or it is
+// detached, the session is closed when it ends.
+func (r *Session) Attach() error {
+	state, err := terminal.MakeRaw(r.in)
+	if err != nil {
+		return err
+	}
+	r.state = state
+	// The terminal may have been resized while the session was detached.
+	if width, height, err := terminal.GetSize(r.in); err == nil {
+		_ = r.sess.WindowChange(height, width)
+	}
+	r.detach = make(chan struct{})
+	r.newline, r.escape = true, false
+	r.output.attach(os.Stdout)
+
+	exit := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+
+	go loop(wg, exit, r.in, r.stdin, r.translate)
+	go resize(wg, exit, r.sess, r.in, r.recorder)
+
+	var detached bool
+	select {
+	case <-r.done:
+	case <-r.detach:
+		detached = true
+	}
+	close(exit)
+	wg.Wait()
+	r.output.detach()
+	_ = terminal.Restore(r.in, r.state)
+
+	if detached {
+		select {
+		case <-r.done:
+		default:
+			return nil
+		}
+	}
+	removeSession(r)
+	r.Close()
+	return r.err
+}
+
+// Disconnect closes the session, the session ends without an error. The
+// connection is kept if it is shared with other sessions.
+func (r *Session) Disconnect() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	doClose(r.sess)
+	// The server may not answer anymore, then the connection is closed. The
+	// shared connection is only closed if it is dead, it is closed for all of
+	// its sessions then.
+	go func() {
+		select {
+		case <-r.done:
+		case <-time.After(time.Second * 3):
+			if r.client.shared == nil {
+				doClose(r.client.Client)
+			} else if err := ping(r.client.Client); err != nil {
+				r.client.shared.close()
+			}
+		}
+	}()
+}
+
+// Kill closes the detached session.
+func (r *Session) Kill() {
+	r.Disconnect()
+	removeSession(r)
+	r.Close()
+}
+
+func (r *Session) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keepalive == nil {
+		return
+	}
+	close(r.keepalive)
+	r.keepalive = nil
+	if r.sess != nil {
+		doClose(r.sess)
+	}
+	r.forwarder.Close()
+	for i, j := 0, len(r.closers); i < j; i++ {
+		doClose(r.closers[i])
+	}
+	doClose(r.client)
+}
+
+// The sessionOutput writes the output to the terminal while the session is
+// attached, otherwise the last output is kept until the session is attached.
+type sessionOutput struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+func (o *sessionOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.w != nil {
+		return o.w.Write(b)
+	}
+	o.buf = append(o.buf, b...)
+	if n := len(o.buf) - maxSessionBuffer; n > 0 {
+		o.buf = append(o.buf[:0], o.buf[n:]...)
+	}
+	return len(b), nil
+}
+
+func (o *sessionOutput) attach(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.buf) > 0 {
+		_, _ = w.Write(o.buf)
+		o.buf = nil
+	}
+	o.w = w
+}
+
+func (o *sessionOutput) buffered() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.buf)
+}
+
+func (o *sessionOutput) detach() {
+	o.mu.Lock()
+	o.w = nil
+	o.mu.Unlock()
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEndedSessions(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	open := &Session{ID: 3, Server: &Server{Name: "db"}, done: make(chan struct{})}
+	sessions = []*Session{
+		{ID: 1, Server: &Server{Name: "web"}, done: done},
+		{ID: 2, Server: &Server{Name: "api"}, done: done, err: &ConnectionLostError{Err: errors.New("EOF")}},
+		open,
+	}
+	defer func() { sessions = nil }()
+
+	if list := Sessions(); len(list) != 1 || list[0] != open {
+		t.Fatalf("got %v", list)
+	}
+	want := []string{"#1 (web)", "#2 (api, connection lost: EOF)"}
+	if got := EndedSessions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q", got)
+	}
+	if got := EndedSessions(); len(got) != 0 {
+		t.Errorf("got %q again", got)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The states of the parser of the ANSI escape sequences.
+const (
+	ansiNone   = iota
+	ansiEscape // after ESC
+	ansiCSI    // after ESC [
+	ansiString // after ESC ] and the other string sequences, ended by BEL or ESC \
+	ansiStringEscape
+	ansiCharset // after ESC ( and the other sequences with intermediate bytes
+)
+
+// SessionLog writes the output of a session to the plain text log files, each
+// line is written with the time it started and the ANSI sequences are removed.
+type SessionLog struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	f       *os.File
+	date    string
+	size    int64
+	line    []byte
+	start   time.Time
+	state   int
+	err     error
+}
+
+func NewSessionLog(s *Server) (*SessionLog, error) {
+	dir := filepath.Join(Cfg.LogDir, s.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if Cfg.LogMaxAge > 0 {
+		removeOldLogs(dir, time.Duration(Cfg.LogMaxAge)*time.Hour*24)
+	}
+	l := &SessionLog{dir: dir, maxSize: int64(Cfg.LogMaxSize) * 1024 * 1024}
+	now := time.Now()
+	if err := l.open(now); err != nil {
+		return nil, err
+	}
+	l.write(now, fmt.Sprintf("=== Session of %s (%s@%s) started ===", s.Name, s.User, s.Addr))
+	if l.err != nil {
+		doClose(l.f)
+		return nil, l.err
+	}
+	return l, nil
+}
+
+func removeOldLogs(dir string, age time.Duration) {
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for i, j := 0, len(list); i < j; i++ {
+		if list[i].Mode().IsRegular() && strings.HasSuffix(list[i].Name(), ".log") && time.Since(list[i].ModTime()) > age {
+			_ = os.Remove(filepath.Join(dir, list[i].Name()))
+		}
+	}
+}
+
+func (l *SessionLog) open(now time.Time) error {
+	date := now.Format("2006-01-02")
+	f, err := os.OpenFile(filepath.Join(l.dir, date+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		doClose(f)
+		return err
+	}
+	l.f, l.date, l.size = f, date, info.Size()
+	return nil
+}
+
+// The rotate function renames the full log file to <date>.<n>.log and opens a new one.
+func (l *SessionLog) rotate(now time.Time) error {
+	doClose(l.f)
+	if date := now.Format("2006-01-02"); date == l.date {
+		name := filepath.Join(l.dir, date+".log")
+		for n := 1; ; n++ {
+			rotated := filepath.Join(l.dir, fmt.Sprintf("%s.%d.log", date, n))
+			if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+				if err = os.Rename(name, rotated); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return l.open(now)
+}
+
+func (l *SessionLog) write(at time.Time, line string) {
+	if l.err != nil {
+		return
+	}
+	text := fmt.Sprintf("[%s] %s\n", at.Format("2006-01-02 15:04:05"), line)
+	if at.Format("2006-01-02") != l.date || (l.maxSize > 0 && l.size > 0 && l.size+int64(len(text)) > l.maxSize) {
+		if l.err = l.rotate(at); l.err != nil {
+			Error("Rotate session log error: %s", l.err)
+			return
+		}
+	}
+	n, err := l.f.WriteString(text)
+	l.size += int64(n)
+	if l.err = err; err != nil {
+		Error("Write session log %s error: %s", l.f.Name(), err)
+	}
+}
+
+func (l *SessionLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, j := 0, len(b); i < j; i++ {
+		c := b[i]
+		switch l.state {
+		case ansiEscape:
+			switch {
+			case c == '[':
+				l.state = ansiCSI
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				l.state = ansiString
+			case c >= 0x20 && c <= 0x2f:
+				l.state = ansiCharset
+			default:
+				l.state = ansiNone
+			}
+			continue
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				l.state = ansiNone
+			}
+			continue
+		case ansiString:
+			if c == 0x07 {
+				l.state = ansiNone
+			} else if c == 0x1b {
+				l.state = ansiStringEscape
+			}
+			continue
+		case ansiStringEscape:
+			if c == '\\' {
+				l.state = ansiNone
+			} else {
+				l.state = ansiString
+			}
+			continue
+		case ansiCharset:
+			if c >= 0x30 && c <= 0x7e {
+				l.state = ansiNone
+			}
+			continue
+		}
+
+		switch {
+		case c == 0x1b:
+			l.state = ansiEscape
+		case c == '\n':
+			l.flush()
+		case c == '\b':
+			// The typed characters are echoed, so the deleted ones are removed too.
+			if len(l.line) > 0 {
+				_, size := utf8.DecodeLastRune(l.line)
+				l.line = l.line[:len(l.line)-size]
+			}
+		case c == '\t' || c >= 0x20 && c != 0x7f:
+			if len(l.line) == 0 {
+				l.start = time.Now()
+			}
+			l.line = append(l.line, c)
+		}
+	}
+	return len(b), nil
+}
+
+func (l *SessionLog) flush() {
+	if len(l.line) > 0 {
+		l.write(l.start, string(l.line))
+		l.line = l.line[:0]
+	} else {
+		l.write(time.Now(), "")
+	}
+}
+
+func (l *SessionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.line) > 0 {
+		l.flush()
+	}
+	l.write(time.Now(), "=== Session ended ===")
+	return l.f.Close()
+}
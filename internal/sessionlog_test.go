@@ -0,0 +1,147 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sessionLogTime = regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\] `)
+
+// The readSessionLog function returns the lines of the log files without the
+// times, the session header and footer lines.
+func readSessionLog(t *testing.T, files ...string) []string {
+	var lines []string
+	for i, j := 0, len(files); i < j; i++ {
+		data, err := ioutil.ReadFile(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if !sessionLogTime.MatchString(line) {
+				t.Fatalf("line without time: %q", line)
+			}
+			if line = sessionLogTime.ReplaceAllString(line, ""); !strings.HasPrefix(line, "=== ") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+func newTestSessionLog(t *testing.T) (*SessionLog, string) {
+	Cfg = NewConfig()
+	Cfg.LogDir = t.TempDir()
+	l, err := NewSessionLog(&Server{Name: "test", User: "root", Addr: "127.0.0.1:22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, filepath.Join(Cfg.LogDir, "test")
+}
+
+func TestSessionLogWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{"plain", []string{"hello\r\nworld\r\n"}, []string{"hello", "world"}},
+		{"split lines", []string{"hel", "lo\r", "\nwor", "ld"}, []string{"hello", "world"}},
+		{"empty line", []string{"a\r\n\r\nb\r\n"}, []string{"a", "", "b"}},
+		{"colors", []string{"\x1b[01;32mroot@host\x1b[00m:\x1b[01;34m~\x1b[00m# ls\r\n"}, []string{"root@host:~# ls"}},
+		{"split sequence", []string{"\x1b[01;3", "4mblue\x1b", "[0m\r\n"}, []string{"blue"}},
+		{"title", []string{"\x1b]0;root@host: ~\x07prompt\x1b]2;t\x1b\\# \r\n"}, []string{"prompt# "}},
+		{"charset", []string{"\x1b(Bplain\x1b)0\r\n"}, []string{"plain"}},
+		{"backspace", []string{"lsx\b \b\b \b -l\r\n"}, []string{"l -l"}},
+		{"multibyte backspace", []string{"你好\b \b\r\n"}, []string{"你"}},
+		{"controls", []string{"a\x07b\x7fc\td\r\n"}, []string{"abc\td"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, dir := newTestSessionLog(t)
+			for _, chunk := range tt.chunks {
+				if n, err := l.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("write %q: %d, %v", chunk, n, err)
+				}
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+			if got := readSessionLog(t, files...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionLogRotate(t *testing.T) {
+	l, dir := newTestSessionLog(t)
+	l.maxSize = 100
+	for i := 0; i < 10; i++ {
+		_, _ = l.Write([]byte("0123456789\r\n"))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Now().Format("2006-01-02")
+	files, _ := filepath.Glob(filepath.Join(dir, date+".*.log"))
+	if len(files) < 2 {
+		t.Fatalf("got %d rotated files, want at least 2", len(files))
+	}
+	files = append(files, filepath.Join(dir, date+".log"))
+	var lines int
+	for i, j := 0, len(files); i < j; i++ {
+		info, err := os.Stat(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > l.maxSize {
+			t.Errorf("%s: size %d is larger than %d", files[i], info.Size(), l.maxSize)
+		}
+		lines += len(readSessionLog(t, files[i]))
+	}
+	if lines != 10 {
+		t.Errorf("got %d lines, want 10", lines)
+	}
+}
+
+func TestRemoveOldLogs(t *testing.T) {
+	dir := t.TempDir()
+	old, recent, other := filepath.Join(dir, "old.log"), filepath.Join(dir, "recent.log"), filepath.Join(dir, "old.txt")
+	for _, file := range []string{old, recent, other} {
+		if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-72 * time.Hour)
+	_ = os.Chtimes(old, past, past)
+	_ = os.Chtimes(other, past, past)
+
+	removeOldLogs(dir, 48*time.Hour)
+	for file, exist := range map[string]bool{old: false, recent: true, other: true} {
+		if _, err := os.Stat(file); (err == nil) != exist {
+			t.Errorf("%s: exist %t, want %t", file, err == nil, exist)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// SFTP is a SFTP session of a connected remote server.
+type SFTP struct {
+	*sftp.Client
+	client *Client
+}
+
+func (s *SFTP) Close() error {
+	err := s.Client.Close()
+	doClose(s.client)
+	return err
+}
+
+func OpenSFTP(s *Server) (*SFTP, error) {
+	client, err := Dial(s)
+	if err != nil {
+		return nil, err
+	}
+	c, err := sftp.NewClient(client.Client)
+	if err != nil {
+		doClose(client)
+		return nil, fmt.Errorf("start sftp subsystem: %s", err)
+	}
+	return &SFTP{Client: c, client: client}, nil
+}
+
+// The parseRemotePath function parses the remote path in the form <name>:<path>.
+func parseRemotePath(spec string) (*Server, string, error) {
+	n := strings.Index(spec, ":")
+	if n <= 0 {
+		return nil, "", fmt.Errorf("invalid remote path %q, expected <name>:<path>", spec)
+	}
+	s, err := findServer(spec[:n])
+	if err != nil {
+		return nil, "", err
+	}
+	return s, spec[n+1:], nil
+}
+
+func Put(local, remote string) error {
+	s, p, err := parseRemotePath(remote)
+	if err != nil {
+		return err
+	}
+	c, err := OpenSFTP(s)
+	if err != nil {
+		return err
+	}
+	defer doClose(c)
+
+	return c.Upload(local, p)
+}
+
+func Get(remote, local string) error {
+	s, p, err := parseRemotePath(remote)
+	if err != nil {
+		return err
+	}
+	c, err := OpenSFTP(s)
+	if err != nil {
+		return err
+	}
+	defer doClose(c)
+
+	return c.Download(p, local)
+}
+
+// The relative remote paths are relative to the home directory of the user.
+func (s *SFTP) path(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = strings.TrimPrefix(p[1:], "/")
+	}
+	if p == "" {
+		return "."
+	}
+	return path.Clean(p)
+}
+
+// Upload copies the local file or directory to the remote path, like scp, the
+// local one is copied into the remote path if it is an existing directory.
+func (s *SFTP) Upload(local, remote string) error {
+	local = Cfg.path(local)
+	if _, err := os.Stat(local); err != nil {
+		return err
+	}
+	remote = s.path(remote)
+	if info, err := s.Stat(remote); err == nil && info.IsDir() {
+		remote = path.Join(remote, filepath.Base(local))
+	}
+
+	t := newTransfer("Uploaded")
+	var dirs []string
+	var times []time.Time
+	err := filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remote, filepath.ToSlash(rel))
+		switch {
+		case info.IsDir():
+			if err := s.MkdirAll(target); err != nil {
+				return fmt.Errorf("create remote directory %s: %s", target, err)
+			}
+			if err := s.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			// The mtime of a directory changes when files are created in it.
+			dirs, times = append(dirs, target), append(times, info.ModTime())
+		case info.Mode().IsRegular():
+			if err := s.put(t, p, target, info); err != nil {
+				return err
+			}
+		default:
+			Warn("Skip %s: not a regular file", p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; err == nil && i >= 0; i-- {
+		err = s.Chtimes(dirs[i], times[i], times[i])
+	}
+	t.done(err)
+	return err
+}
+
+func (s *SFTP) put(t *transfer, local, remote string, info os.FileInfo) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer doClose(src)
+
+	dst, err := s.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("create remote file %s: %s", remote, err)
+	}
+	defer doClose(dst)
+
+	p := t.file(remote, info.Size())
+	_, err = io.Copy(dst, io.TeeReader(src, p))
+	p.done(err)
+	if err != nil {
+		return err
+	}
+	if err = s.Chmod(remote, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return s.Chtimes(remote, info.ModTime(), info.ModTime())
+}
+
+// Download copies the remote file or directory to the local path, like scp, the
+// remote one is copied into the local path if it is an existing directory.
+func (s *SFTP) Download(remote, local string) error {
+	remote = s.path(remote)
+	if _, err := s.Stat(remote); err != nil {
+		return err
+	}
+	local = Cfg.path(local)
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		if remote == "." {
+			wd, err := s.Getwd()
+			if err != nil {
+				return err
+			}
+			local = filepath.Join(local, path.Base(wd))
+		} else {
+			local = filepath.Join(local, path.Base(remote))
+		}
+	}
+
+	t := newTransfer("Downloaded")
+	var dirs []string
+	var times []time.Time
+	var err error
+	for walker := s.Walk(remote); err == nil && walker.Step(); {
+		if err = walker.Err(); err != nil {
+			break
+		}
+		info := walker.Stat()
+		rel := walker.Path()
+		if remote != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(rel, remote), "/")
+		} else if rel == "." {
+			rel = ""
+		}
+		target := filepath.Join(local, filepath.FromSlash(rel))
+		switch {
+		case info.IsDir():
+			if err = os.MkdirAll(target, 0755); err == nil {
+				err = os.Chmod(target, info.Mode().Perm())
+			}
+			dirs, times = append(dirs, target), append(times, info.ModTime())
+		case info.Mode().IsRegular():
+			err = s.get(t, walker.Path(), target, info)
+		default:
+			Warn("Skip %s: not a regular file", walker.Path())
+		}
+	}
+	for i := len(dirs) - 1; err == nil && i >= 0; i-- {
+		err = os.Chtimes(dirs[i], times[i], times[i])
+	}
+	t.done(err)
+	return err
+}
+
+func (s *SFTP) get(t *transfer, remote, local string, info os.FileInfo) error {
+	src, err := s.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer doClose(src)
+
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer doClose(dst)
+
+	p := t.file(local, info.Size())
+	_, err = io.Copy(io.MultiWriter(dst, p), src)
+	p.done(err)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(local, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(local, info.ModTime(), info.ModTime())
+}
+
+type transfer struct {
+	action string
+	start  time.Time
+	files  int
+	size   int64
+}
+
+func newTransfer(action string) *transfer {
+	return &transfer{action: action, start: time.Now()}
+}
+
+func (t *transfer) file(name string, size int64) *progress {
+	t.files++
+	t.size += size
+	return &progress{
+		name:  name,
+		total: size,
+		start: time.Now(),
+		tty:   terminal.IsTerminal(int(os.Stdout.Fd())),
+	}
+}
+
+func (t *transfer) done(err error) {
+	if err == nil {
+		Echo(color.GreenString("%s %d file(s), %s in %s.", t.action, t.files, formatSize(t.size),
+			time.Since(t.start).Round(time.Millisecond)))
+	}
+}
+
+// The progress shows the progress of transferring one file.
+type progress struct {
+	name  string
+	total int64
+	n     int64
+	start time.Time
+	last  time.Time
+	tty   bool
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if p.tty && time.Since(p.last) >= time.Millisecond*200 {
+		p.last = time.Now()
+		fmt.Printf("\r%s\033[K", p.line())
+	}
+	return len(b), nil
+}
+
+func (p *progress) done(err error) {
+	if p.tty {
+		fmt.Print("\r\033[K")
+	}
+	if err == nil {
+		Echo(p.line())
+	}
+}
+
+func (p *progress) line() string {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.n * 100 / p.total
+	}
+	var speed int64
+	if d := time.Since(p.start); d > 0 {
+		speed = int64(float64(p.n) / d.Seconds())
+	}
+	return fmt.Sprintf("%s  %3d%%  %s/%s  %s/s", p.name, percent, formatSize(p.n), formatSize(p.total), formatSize(speed))
+}
+
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size, i := float64(n), 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+
+	socksSucceeded           = 0
+	socksHostUnreachable     = 4
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+// The socks function negotiates a SOCKS5 CONNECT request on the given
+// connection, and connects to the requested address with the dial function.
+func socks(conn net.Conn, dial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 30))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksVersion {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", buf[0])
+	}
+	methods := buf[2 : 2+int(buf[1])]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+	method := byte(socksNoAcceptable)
+	for i, j := 0, len(methods); i < j; i++ {
+		if methods[i] == socksNoAuth {
+			method = socksNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return nil, err
+	}
+	if method == socksNoAcceptable {
+		return nil, errors.New("no acceptable SOCKS authentication method")
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, err
+	}
+	if buf[1] != socksConnect {
+		socksReply(conn, socksCommandNotSupported)
+		return nil, fmt.Errorf("unsupported SOCKS command %d", buf[1])
+	}
+	var host string
+	switch buf[3] {
+	case socksIPv4:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return nil, err
+		}
+		host = net.IP(buf[:4]).String()
+	case socksIPv6:
+		if _, err := io.ReadFull(conn, buf[:16]); err != nil {
+			return nil, err
+		}
+		host = net.IP(buf[:16]).String()
+	case socksDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+		host = string(buf[:n])
+	default:
+		socksReply(conn, socksAddressNotSupported)
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", buf[3])
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	remote, err := dial("tcp", addr)
+	if err != nil {
+		socksReply(conn, socksHostUnreachable)
+		return nil, fmt.Errorf("connect to %s: %s", addr, err)
+	}
+	if err = socksReply(conn, socksSucceeded); err != nil {
+		doClose(remote)
+		return nil, err
+	}
+	return remote, nil
+}
+
+func socksReply(conn net.Conn, code byte) error {
+	// The bound address is not meaningful for a tunneled connection.
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
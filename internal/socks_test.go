@@ -0,0 +1,165 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// The socksRequest function sends a SOCKS5 CONNECT request of the address type
+// and returns the reply code.
+func socksRequest(t *testing.T, proxy net.Addr, atyp byte, host []byte, port int) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", proxy.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	if _, err = conn.Write([]byte{socksVersion, 1, socksNoAuth}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != socksVersion || buf[1] != socksNoAuth {
+		t.Fatalf("unexpected method selection: %v", buf[:2])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0, atyp}
+	if atyp == socksDomain {
+		req = append(req, byte(len(host)))
+	}
+	req = append(req, host...)
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	if _, err = conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	return conn, buf[1]
+}
+
+func TestForwarderDynamic(t *testing.T) {
+	bastion, target := newSSHTestServer(t), newSSHTestServer(t)
+	direct, jumped := bastion.server(t, "bastion"), target.server(t, "target")
+	jumped.Jump = StringList{"bastion"}
+	newTestConfig(t, direct, jumped)
+
+	echo := newEchoServer(t)
+	port := echo.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+
+	for _, s := range []*Server{direct, jumped} {
+		t.Run(s.Name, func(t *testing.T) {
+			client, err := Dial(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer doClose(client)
+			if s == jumped && len(client.hops) != 1 {
+				t.Fatalf("got %d jump hosts, want 1", len(client.hops))
+			}
+
+			forwarder := NewForwarder(client.Client)
+			defer forwarder.Close()
+			addr, err := forwarder.Dynamic("127.0.0.1", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name string
+				atyp byte
+				host []byte
+			}{
+				{"ipv4", socksIPv4, []byte{127, 0, 0, 1}},
+				{"domain", socksDomain, []byte("localhost")},
+			}
+			for _, tt := range tests {
+				conn, code := socksRequest(t, addr, tt.atyp, tt.host, port)
+				if code != socksSucceeded {
+					t.Fatalf("%s: reply code %d, want %d", tt.name, code, socksSucceeded)
+				}
+				msg := []byte("hello " + tt.name)
+				if _, err = conn.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+				got := make([]byte, len(msg))
+				if _, err = io.ReadFull(conn, got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, msg) {
+					t.Fatalf("%s: got %q, want %q", tt.name, got, msg)
+				}
+			}
+
+			if _, code := socksRequest(t, addr, socksIPv4, []byte{127, 0, 0, 1}, unused); code != socksHostUnreachable {
+				t.Fatalf("closed port: reply code %d, want %d", code, socksHostUnreachable)
+			}
+		})
+	}
+}
+
+func TestSocksUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		req  []byte
+		want []byte
+	}{
+		{"no acceptable method", []byte{socksVersion, 1, 2}, []byte{socksVersion, socksNoAcceptable}},
+		{"bind command", []byte{socksVersion, 1, socksNoAuth, socksVersion, 2, 0, socksIPv4, 127, 0, 0, 1, 0, 80},
+			[]byte{socksVersion, socksNoAuth, socksVersion, socksCommandNotSupported}},
+		{"address type", []byte{socksVersion, 1, socksNoAuth, socksVersion, socksConnect, 0, 9},
+			[]byte{socksVersion, socksNoAuth, socksVersion, socksAddressNotSupported}},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		errs := make(chan error, 1)
+		go func() {
+			_, err := socks(server, func(string, string) (net.Conn, error) {
+				t.Error("unexpected dial")
+				return nil, io.EOF
+			})
+			_ = server.Close()
+			errs <- err
+		}()
+		go func() { _, _ = client.Write(tt.req) }()
+		got, _ := io.ReadAll(client)
+		if len(got) > len(tt.want) {
+			got = got[:len(tt.want)]
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if err := <-errs; err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+		_ = client.Close()
+	}
+}
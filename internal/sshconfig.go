@@ -0,0 +1,258 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The maximum depth of the nested Include directives, like OpenSSH.
+const maxSSHConfigDepth = 16
+
+// The sshConfigBlock is a Host block of the ssh config file, the options before
+// the first Host block belong to a block matching all hosts.
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+func (b *sshConfigBlock) match(host string) bool {
+	var matched bool
+	for i, j := 0, len(b.patterns); i < j; i++ {
+		if strings.HasPrefix(b.patterns[i], "!") {
+			if matchPattern(b.patterns[i][1:], host) {
+				return false
+			}
+		} else if matchPattern(b.patterns[i], host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// The matchPattern function matches the host with the pattern, * matches any
+// characters and ? matches exactly one character.
+func matchPattern(pattern, s string) bool {
+	var p, i, star, mark = 0, 0, -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			p, mark = star+1, mark+1
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+type sshConfigParser struct {
+	blocks []*sshConfigBlock
+	match  bool // the lines belong to a Match block, which is not supported
+}
+
+// LoadSSHConfig reads the hosts of the ssh config file as servers of the group,
+// the hosts with wildcards only provide options to the other hosts.
+func LoadSSHConfig(file, group string) ([]*Server, error) {
+	p := &sshConfigParser{blocks: []*sshConfigBlock{{patterns: []string{"*"}}}}
+	if err := p.parse(file, 0); err != nil {
+		return nil, err
+	}
+
+	var servers []*Server
+	seen := make(map[string]bool)
+	for i, j := 0, len(p.blocks); i < j; i++ {
+		for _, host := range p.blocks[i].patterns {
+			if seen[host] || strings.ContainsAny(host, "*?!") {
+				continue
+			}
+			seen[host] = true
+			s, err := p.server(host, group)
+			if err != nil {
+				return nil, fmt.Errorf("%s: host %s: %s", file, host, err)
+			}
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func (p *sshConfigParser) parse(file string, depth int) error {
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("%s: too many nested includes", file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer doClose(f)
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		key, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s", file, n, err)
+		}
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			p.blocks = append(p.blocks, &sshConfigBlock{patterns: args})
+			p.match = false
+		case "match":
+			p.match = true
+		case "include":
+			block, match := p.blocks[len(p.blocks)-1], p.match
+			for i, j := 0, len(args); i < j; i++ {
+				pattern := Cfg.path(args[i])
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+				}
+				files, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s line %d: %s", file, n, err)
+				}
+				for k, l := 0, len(files); k < l; k++ {
+					if err = p.parse(files[k], depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			// The Host blocks of the included files end at the end of the files.
+			if p.blocks[len(p.blocks)-1] != block {
+				p.blocks = append(p.blocks, &sshConfigBlock{patterns: block.patterns})
+			}
+			p.match = match
+		default:
+			if !p.match && len(args) > 0 {
+				block := p.blocks[len(p.blocks)-1]
+				for i, j := 0, len(args); i < j; i++ {
+					block.options = append(block.options, [2]string{key, args[i]})
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// The splitSSHConfigLine function splits the line into the lower case keyword
+// and its arguments, the keyword may be separated by = and the arguments may be quoted.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	n := strings.IndexAny(line, " \t=")
+	if n < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:n])
+	rest := strings.TrimLeft(line[n:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var args []string
+	for rest != "" {
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			args = append(args, rest[1:end+1])
+			rest = rest[end+2:]
+		} else if end := strings.IndexAny(rest, " \t"); end >= 0 {
+			args = append(args, rest[:end])
+			rest = rest[end:]
+		} else {
+			args = append(args, rest)
+			rest = ""
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return key, args, nil
+}
+
+// The server function applies the options of the matched blocks to the host,
+// the first obtained value of an option is used, except for IdentityFile.
+func (p *sshConfigParser) server(host, group string) (*Server, error) {
+	values := make(map[string]string)
+	var identities []string
+	for i, j := 0, len(p.blocks); i < j; i++ {
+		if !p.blocks[i].match(host) {
+			continue
+		}
+		for _, option := range p.blocks[i].options {
+			if option[0] == "identityfile" {
+				identities = append(identities, option[1])
+			} else if _, found := values[option[0]]; !found {
+				values[option[0]] = option[1]
+			}
+		}
+	}
+
+	s := &Server{Name: host, Host: host, User: values["user"], Group: group}
+	if hostname := values["hostname"]; hostname != "" {
+		s.Host = strings.ReplaceAll(hostname, "%h", host)
+	}
+	if port := values["port"]; port != "" {
+		var err error
+		if s.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("bad port %s", port)
+		}
+	}
+	// The missing identity files are ignored, just like OpenSSH does.
+	for i, j := 0, len(identities); i < j; i++ {
+		key := expandSSHTokens(identities[i], s)
+		if _, err := os.Stat(Cfg.path(key)); err == nil {
+			s.PrivateKeys = append(s.PrivateKeys, key)
+		}
+	}
+	if jump := values["proxyjump"]; jump != "" && jump != "none" {
+		for _, hop := range strings.Split(jump, ",") {
+			if hop = strings.TrimSpace(strings.TrimPrefix(hop, "ssh://")); hop != "" {
+				s.Jump = append(s.Jump, hop)
+			}
+		}
+	}
+	return s, nil
+}
+
+func expandSSHTokens(s string, server *Server) string {
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%d", os.Getenv("HOME"),
+		"%u", os.Getenv("USER"),
+		"%h", server.Host,
+		"%n", server.Name,
+		"%r", server.User,
+	)
+	return replacer.Replace(s)
+}
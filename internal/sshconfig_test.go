@@ -0,0 +1,154 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSSHConfigLine(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		args []string
+		err  bool
+	}{
+		{"", "", nil, false},
+		{"   # comment", "", nil, false},
+		{"Host web db", "host", []string{"web", "db"}, false},
+		{"\tHostName\t10.0.0.5  ", "hostname", []string{"10.0.0.5"}, false},
+		{"Port=2222", "port", []string{"2222"}, false},
+		{"Port = 2222", "port", []string{"2222"}, false},
+		{"User =admin", "user", []string{"admin"}, false},
+		{`IdentityFile "~/.ssh/my key"`, "identityfile", []string{"~/.ssh/my key"}, false},
+		{`Include "a b" c`, "include", []string{"a b", "c"}, false},
+		{"Compression", "compression", nil, false},
+		{`IdentityFile "~/.ssh/id`, "", nil, true},
+	}
+	for _, tt := range tests {
+		key, args, err := splitSSHConfigLine(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.line, err)
+			continue
+		}
+		if key != tt.key || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%q: got %q %q, want %q %q", tt.line, key, args, tt.key, tt.args)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "web", true},
+		{"web", "web", true},
+		{"web", "web1", false},
+		{"web?", "web1", true},
+		{"web?", "web", false},
+		{"web*", "web", true},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"10.0.*.5", "10.0.1.5", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %t, want %t", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSSHConfig(t *testing.T) {
+	home := t.TempDir()
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	_ = os.Setenv("HOME", home)
+	newTestConfig(t)
+
+	dir := filepath.Join(home, ".ssh")
+	files := map[string]string{
+		"config": `
+User default
+IdentityFile ~/.ssh/id_%h
+IdentityFile ~/.ssh/missing
+
+Host web db
+    HostName %h.example.com
+    Port 2222
+
+Host db
+    Port 3333
+    User dba
+
+Include conf.d/*
+
+Host *.internal !skip.internal
+    ProxyJump ssh://bastion,admin@jump:2200
+
+Host app.internal skip.internal
+
+Match host web
+    User nobody
+`,
+		"conf.d/extra": `
+Host extra
+    HostName 10.0.0.9
+`,
+		"id_web.example.com": "",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	servers, err := LoadSSHConfig(filepath.Join(dir, "config"), "imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Server{
+		{Name: "web", Host: "web.example.com", Port: 2222, User: "default", Group: "imported",
+			PrivateKeys: []string{"~/.ssh/id_web.example.com"}},
+		{Name: "db", Host: "db.example.com", Port: 2222, User: "default", Group: "imported"},
+		{Name: "extra", Host: "10.0.0.9", User: "default", Group: "imported"},
+		{Name: "app.internal", Host: "app.internal", User: "default", Group: "imported",
+			Jump: StringList{"bastion", "admin@jump:2200"}},
+		{Name: "skip.internal", Host: "skip.internal", User: "default", Group: "imported"},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("got %d servers, want %d", len(servers), len(want))
+	}
+	for i, j := 0, len(want); i < j; i++ {
+		if !reflect.DeepEqual(servers[i], want[i]) {
+			t.Errorf("server %d: got %+v, want %+v", i, servers[i], want[i])
+		}
+	}
+
+	if _, err = LoadSSHConfig(filepath.Join(dir, "none"), "imported"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
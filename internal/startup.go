@@ -0,0 +1,184 @@
+// Copyright 2021 Qingshan Luo <edoger@qq.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The time to wait for the shell of the become command.
+const (
+	becomeTimeout = 10 * time.Second
+	becomeIdle    = 2 * time.Second
+)
+
+var passwordPrompt = regexp.MustCompile(`(?i)(password|密码)[^\n]*[:：]\s*$`)
+
+// The messages of sudo and su when the password is wrong.
+var becomeFailures = []string{"Sorry, try again", "Authentication failure", "incorrect password"}
+
+// The startup type types the post-login actions of the server into the shell,
+// it watches the output of the shell to answer the password prompt of become.
+type startup struct {
+	server *Server
+	stdin  io.Writer // the input of the shell, which is not recorded
+
+	mu     sync.Mutex
+	buf    []byte // the recent output of the shell
+	done   bool
+	notify chan struct{}
+}
+
+func newStartup(s *Server, stdin io.Writer) *startup {
+	if s.Become == "" && s.Workdir == "" && len(s.StartupCommands) == 0 {
+		return nil
+	}
+	return &startup{server: s, stdin: stdin, notify: make(chan struct{}, 1)}
+}
+
+func (p *startup) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if !p.done {
+		p.buf = append(p.buf, b...)
+		if n := len(p.buf) - 1024; n > 0 {
+			p.buf = append(p.buf[:0], p.buf[n:]...)
+		}
+	}
+	p.mu.Unlock()
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (p *startup) output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return string(p.buf)
+}
+
+func (p *startup) write(s string) error {
+	p.mu.Lock()
+	p.buf = p.buf[:0]
+	p.mu.Unlock()
+	_, err := io.WriteString(p.stdin, s)
+	return err
+}
+
+// The run function runs the become command first, because it changes the working
+// directory of the shell, then the working directory and the startup commands.
+func (p *startup) run() {
+	defer func() {
+		p.mu.Lock()
+		p.done, p.buf = true, nil
+		p.mu.Unlock()
+	}()
+
+	s := p.server
+	if s.Become != "" {
+		if err := p.become(); err != nil {
+			Warn("Become %s on server %s error: %s, the startup commands are skipped.", s.Become, s.Name, err)
+			return
+		}
+	}
+	var lines []string
+	if s.Workdir != "" {
+		lines = append(lines, "cd "+quoteShellPath(s.Workdir))
+	}
+	lines = append(lines, s.StartupCommands...)
+	for i, j := 0, len(lines); i < j; i++ {
+		if err := p.write(lines[i] + "\n"); err != nil {
+			return
+		}
+	}
+}
+
+func (p *startup) become() error {
+	s := p.server
+	command := "sudo -i"
+	if s.Become == "su" {
+		command = "su -"
+	}
+	if err := p.write(command + "\n"); err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(becomeTimeout)
+	defer timeout.Stop()
+	idle := time.NewTimer(becomeIdle)
+	defer idle.Stop()
+
+	var answered bool
+	for {
+		select {
+		case <-p.notify:
+			output := p.output()
+			if answered {
+				for i, j := 0, len(becomeFailures); i < j; i++ {
+					if strings.Contains(output, becomeFailures[i]) {
+						_ = p.write("\x03")
+						return fmt.Errorf("authentication failed")
+					}
+				}
+			}
+			if passwordPrompt.MatchString(output) {
+				if answered {
+					_ = p.write("\x03")
+					return fmt.Errorf("authentication failed")
+				}
+				password := s.BecomePassword
+				if password == "" {
+					password = s.Password
+				}
+				if password == "" {
+					return fmt.Errorf("no password is configured")
+				}
+				// The terminal does not echo the password.
+				if err := p.write(password + "\n"); err != nil {
+					return err
+				}
+				answered = true
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(becomeIdle)
+		case <-idle.C:
+			// The shell has been started when it is waiting for the input.
+			return nil
+		case <-timeout.C:
+			return fmt.Errorf("timed out after %s", becomeTimeout)
+		}
+	}
+}
+
+// The quoteShellPath function quotes the path for the shell, the leading ~ is
+// left unquoted to be expanded by the shell.
+func quoteShellPath(s string) string {
+	var prefix string
+	if s == "~" {
+		return s
+	}
+	if strings.HasPrefix(s, "~/") {
+		prefix, s = s[:2], s[2:]
+	}
+	return prefix + "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
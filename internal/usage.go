@@ -39,6 +39,8 @@ func ShowUsageGuide() {
 	texts = append(texts, "* Enter the number/name and press <Enter> to automatically connect to")
 	texts = append(texts, "  the corresponding remote server.")
 	texts = append(texts, "* Use <Control+D> to exit J2.")
+	texts = append(texts, "* Type ~? after a newline in a session to list the escape sequences,")
+	texts = append(texts, "  ~^Z detaches the session and ~. disconnects it.")
 
 	prefix := strings.Repeat(" ", 5)
 	Echo(prefix + color.GreenString("J2 Usage Guide:"))
@@ -65,6 +67,12 @@ func CheckAndPrintUsageGuide() {
 			Echo(prefix + color.GreenString("  -v, -version, --version"))
 			Echo(prefix + color.GreenString("    Print J2 version and exit."))
 			Echo("")
+			Echo(prefix + color.GreenString("Commands:"))
+			for k, n := 0, len(Commands); k < n; k++ {
+				Echo(prefix + color.GreenString("  j2 %s %s", Commands[k].Name, Commands[k].Args))
+				Echo(prefix + color.GreenString("    %s", Commands[k].Description))
+			}
+			Echo("")
 			Exit(0)
 		}
 	}